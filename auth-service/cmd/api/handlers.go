@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/auth/data"
+	"github.com/BlackSound1/go-microservices/auth/tokens"
 )
 
 type RoundTripFunc func(req *http.Request) *http.Response
@@ -17,7 +21,24 @@ func NewTestClient(fn RoundTripFunc) *http.Client {
 	return &http.Client{Transport: fn}
 }
 
-// Authenticate validates a user's credentials
+// TokenResponse is sent back on a successful login or refresh
+type TokenResponse struct {
+	User                  *data.User `json:"user"`
+	AccessToken           string     `json:"access_token"`
+	TokenType             string     `json:"token_type"`
+	ExpiresAt             time.Time  `json:"expires_at"`
+	RefreshToken          string     `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time  `json:"refresh_token_expires_at"`
+}
+
+// refreshPayload is what /refresh and /logout expect to receive
+type refreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Authenticate validates a user's credentials and issues them an access token
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 
 	// A request should look like this
@@ -47,6 +68,12 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Deactivated users can't log in
+	if user.Active != 1 {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
 	// Log auth request
 	err = app.logRequest("auth", user.Email+" logged in")
 	if err != nil {
@@ -54,26 +81,222 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Issue tokens so later requests don't need the password again
+	tokenResponse, err := app.issueTokens(*user, nil)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	// Create response to send back
 	payload := JSONResponse{
 		Error:   false,
 		Message: "Logged in user " + user.Email + " successfully",
-		Data:    user,
+		Data:    tokenResponse,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+//
+// Every refresh token can only be used once. If an already used token is presented
+// again, it has probably been stolen, so every token in its family is revoked.
+func (app *Config) Refresh(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload refreshPayload
+
+	// Read the request and save it into the payload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Look up the stored token
+	stored, err := app.Repo.GetRefreshTokenByHash(tokens.HashRefreshToken(requestPayload.RefreshToken))
+	if err != nil {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	// Reuse of a rotated token revokes the whole family
+	if stored.RevokedAt != nil {
+		_ = app.Repo.RevokeRefreshTokenFamily(stored.FamilyID)
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	// Get the user the token belongs to, who must still be active
+	user, err := app.Repo.GetByID(stored.UserID)
+	if err != nil || user.Active != 1 {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	// Rotate the refresh token and issue a new access token
+	tokenResponse, err := app.issueTokens(*user, stored)
+	if errors.Is(err, data.ErrRefreshTokenRevoked) {
+		// Someone else used this token between looking it up and rotating it
+		_ = app.Repo.RevokeRefreshTokenFamily(stored.FamilyID)
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "Refreshed tokens for user " + user.Email,
+		Data:    tokenResponse,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Logout revokes the given refresh token along with the rest of its family, ending
+// that login. Access tokens that were already issued stay valid until they expire.
+func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload refreshPayload
+
+	// Read the request and save it into the payload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Look up the stored token
+	stored, err := app.Repo.GetRefreshTokenByHash(tokens.HashRefreshToken(requestPayload.RefreshToken))
+	if err != nil {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Repo.RevokeRefreshTokenFamily(stored.FamilyID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "logged out",
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
+// issueTokens issues a new access token and refresh token for the given user.
+//
+// If previous is nil, the refresh token starts a new family. Otherwise, it replaces
+// previous in the same family, and previous is revoked.
+func (app *Config) issueTokens(user data.User, previous *data.RefreshToken) (TokenResponse, error) {
+
+	accessToken, expiresAt, err := app.Tokens.Issue(user)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, hash, err := tokens.NewRefreshToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	stored := data.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(app.Tokens.RefreshTTL()),
+	}
+
+	if previous == nil {
+		// Start a new family
+		stored.FamilyID, err = tokens.NewFamilyID()
+		if err != nil {
+			return TokenResponse{}, err
+		}
+
+		_, err = app.Repo.InsertRefreshToken(stored)
+	} else {
+		// Carry on the previous token's family
+		stored.FamilyID = previous.FamilyID
+
+		_, err = app.Repo.RotateRefreshToken(previous.ID, stored)
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		User:                  &user,
+		AccessToken:           accessToken,
+		TokenType:             "Bearer",
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+// Validate verifies an access token and sends back its claims.
+//
+// The token can be given as an "Authorization: Bearer" header, or as
+// the "token" field of a JSON body.
+func (app *Config) Validate(w http.ResponseWriter, r *http.Request) {
+
+	token := bearerToken(r)
+
+	// Fall back to the body if there was no header
+	if token == "" {
+		var requestPayload struct {
+			Token string `json:"token"`
+		}
+
+		err := app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		token = requestPayload.Token
+	}
+
+	// Verify the token
+	claims, err := app.Tokens.Verify(token)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "token is valid",
+		Data:    claims,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
 // logRequest sends a log entry with the specified name and data to the logger service.
 func (app *Config) logRequest(name, data string) error {
 
 	// Create object for given entry data
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		Name   string `json:"name"`
+		Data   string `json:"data"`
+		Level  string `json:"level"`
+		Source string `json:"source"`
 	}
 	entry.Name = name
 	entry.Data = data
+	entry.Level = "INFO"
+	entry.Source = "auth-service"
 
 	// Convert it to JSON
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
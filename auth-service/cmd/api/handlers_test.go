@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/BlackSound1/go-microservices/auth/tokens"
 )
 
 func Test_Authenticate(t *testing.T) {
@@ -46,3 +48,110 @@ func Test_Authenticate(t *testing.T) {
 		t.Errorf("expected http.StatusAccepted but got %d", rr.Code)
 	}
 }
+
+func Test_Validate(t *testing.T) {
+	user, _ := testApp.Repo.GetByEmail("me@me.me")
+
+	token, _, err := testApp.Tokens.Issue(*user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		header       string
+		body         string
+		expectedCode int
+	}{
+		{"bearer header", "Bearer " + token, "", http.StatusOK},
+		{"token in body", "", `{"token":"` + token + `"}`, http.StatusOK},
+		{"tampered token", "Bearer " + token + "x", "", http.StatusUnauthorized},
+		{"no token", "", `{}`, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/validate", bytes.NewBufferString(e.body))
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.Validate)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_RefreshRotation(t *testing.T) {
+	user, _ := testApp.Repo.GetByEmail("me@me.me")
+
+	login, err := testApp.issueTokens(*user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) (int, TokenResponse) {
+		body, _ := json.Marshal(map[string]string{"refresh_token": token})
+		req, _ := http.NewRequest("POST", "/refresh", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(testApp.Refresh).ServeHTTP(rr, req)
+
+		var response struct {
+			Data TokenResponse `json:"data"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+
+		return rr.Code, response.Data
+	}
+
+	// The first refresh rotates the token
+	code, rotated := refresh(login.RefreshToken)
+	if code != http.StatusAccepted {
+		t.Fatalf("expected http.StatusAccepted but got %d", code)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == login.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+
+	// Reusing the old token fails, and revokes the new one along with it
+	if code, _ = refresh(login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected reuse to be rejected but got %d", code)
+	}
+	if code, _ = refresh(rotated.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected the rest of the family to be revoked but got %d", code)
+	}
+
+	if code, _ = refresh("made-up"); code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown token to be rejected but got %d", code)
+	}
+}
+
+func Test_Logout(t *testing.T) {
+	user, _ := testApp.Repo.GetByEmail("me@me.me")
+
+	login, err := testApp.issueTokens(*user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": login.RefreshToken})
+	req, _ := http.NewRequest("POST", "/logout", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(testApp.Logout).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected http.StatusAccepted but got %d", rr.Code)
+	}
+
+	stored, _ := testApp.Repo.GetRefreshTokenByHash(tokens.HashRefreshToken(login.RefreshToken))
+	if stored.RevokedAt == nil {
+		t.Error("expected the refresh token to be revoked")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 )
 
 type JSONResponse struct {
@@ -93,3 +94,17 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	// Send the response
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// bearerToken returns the token from the request's "Authorization: Bearer" header,
+// or an empty string if there isn't one.
+func bearerToken(r *http.Request) string {
+
+	header := r.Header.Get("Authorization")
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/BlackSound1/go-microservices/auth/data"
+	"github.com/BlackSound1/go-microservices/auth/tokens"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -21,6 +22,7 @@ var counts int64
 type Config struct {
 	Repo   data.Repository
 	Client *http.Client
+	Tokens *tokens.Manager
 }
 
 func main() {
@@ -31,16 +33,29 @@ func main() {
 		log.Panic("Can't connect to Postgres")
 	}
 
+	// Bring databases made by older versions up to date
+	err := data.Migrate(conn)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tokenManager, err := createTokenManager()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
 		Client: &http.Client{},
+		Tokens: tokenManager,
 	}
+	app.setupRepo(conn)
 
 	srv := &http.Server{
 		Addr:    ":" + WEB_PORT,
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -90,6 +105,50 @@ func connectToDB() *sql.DB {
 	}
 }
 
+// createTokenManager reads in environment variables and creates a token Manager based on them.
+//
+// JWT_ALGORITHM picks between "HS256" (the default, signed with JWT_SECRET, which must
+// be at least 32 random characters) and "RS256" (signed with the PEM key in
+// JWT_PRIVATE_KEY_FILE). JWT_ACCESS_TTL and JWT_REFRESH_TTL are durations like "15m"
+// or "720h".
+func createTokenManager() (*tokens.Manager, error) {
+
+	config := tokens.Config{
+		Algorithm: os.Getenv("JWT_ALGORITHM"),
+		Secret:    []byte(os.Getenv("JWT_SECRET")),
+		Issuer:    os.Getenv("JWT_ISSUER"),
+		Audience:  os.Getenv("JWT_AUDIENCE"),
+	}
+
+	// Use the default TTLs unless they are given
+	if ttl := os.Getenv("JWT_ACCESS_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+		config.AccessTTL = d
+	}
+
+	if ttl := os.Getenv("JWT_REFRESH_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+		config.RefreshTTL = d
+	}
+
+	// RS256 signs with a private key rather than a shared secret
+	if keyFile := os.Getenv("JWT_PRIVATE_KEY_FILE"); keyFile != "" {
+		key, err := tokens.LoadRSAPrivateKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.PrivateKey = key
+	}
+
+	return tokens.NewManager(config)
+}
+
 func (app *Config) setupRepo(conn *sql.DB) {
 	db := data.NewPostgresRepository(conn)
 	app.Repo = db
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BlackSound1/go-microservices/auth/tokens"
+)
+
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// requireAdmin only lets requests through if they carry a valid access token
+// belonging to an active admin. The token's claims are added to the request context
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Verify the bearer token
+		claims, err := app.Tokens.Verify(bearerToken(r))
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		// Make sure the user is allowed in
+		if !claims.Active || !claims.Admin {
+			app.errorJSON(w, errors.New("admin access required"), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// claimsFromContext returns the access token claims added by requireAdmin
+func claimsFromContext(ctx context.Context) *tokens.Claims {
+	claims, _ := ctx.Value(claimsKey).(*tokens.Claims)
+	return claims
+}
@@ -24,6 +24,22 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping")) // Health check
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/validate", app.Validate)
+	mux.Post("/refresh", app.Refresh)
+	mux.Post("/logout", app.Logout)
+
+	// User management is only for admins
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.AllUsers)
+		mux.Post("/", app.CreateUser)
+		mux.Get("/{id}", app.GetUser)
+		mux.Put("/{id}", app.UpdateUser)
+		mux.Delete("/{id}", app.DeleteUser)
+		mux.Post("/{id}/deactivate", app.DeactivateUser)
+		mux.Post("/{id}/reset-password", app.ResetPassword)
+	})
 
 	return mux
 }
@@ -13,7 +13,16 @@ func Test_routes_exist(t *testing.T) {
 	testRoutes := testApp.routes()
 	chiRoutes := testRoutes.(chi.Router)
 
-	routes := []string{"/authenticate"}
+	routes := []string{
+		"/authenticate",
+		"/validate",
+		"/refresh",
+		"/logout",
+		"/users/",
+		"/users/{id}",
+		"/users/{id}/deactivate",
+		"/users/{id}/reset-password",
+	}
 
 	for _, route := range routes {
 		routeExists(t, chiRoutes, route)
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/BlackSound1/go-microservices/auth/data"
+	"github.com/BlackSound1/go-microservices/auth/tokens"
 )
 
 var testApp Config
@@ -13,5 +14,14 @@ func TestMain(m *testing.M) {
 	repo := data.NewPostgresTestRepository(nil)
 	testApp.Repo = repo
 
+	tokenManager, err := tokens.NewManager(tokens.Config{
+		Algorithm: "HS256",
+		Secret:    []byte("test-secret-that-is-long-enough-to-use"),
+	})
+	if err != nil {
+		panic(err)
+	}
+	testApp.Tokens = tokenManager
+
 	os.Exit(m.Run())
 }
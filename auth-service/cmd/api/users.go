@@ -0,0 +1,401 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/BlackSound1/go-microservices/auth/data"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	MIN_PASSWORD_LENGTH = 8
+	MAX_PASSWORD_LENGTH = 72 // bcrypt ignores anything past 72 bytes
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// userPayload is what creating or updating a user expects to receive. Fields that
+// are left out of an update are left unchanged
+type userPayload struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Password  *string `json:"password"`
+	Active    *int    `json:"active"`
+	Admin     *int    `json:"admin"`
+}
+
+// AllUsers lists every user
+func (app *Config) AllUsers(w http.ResponseWriter, r *http.Request) {
+
+	users, err := app.Repo.GetAll()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "users",
+		Data:    users,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateUser creates a new user from the given email, name and password
+func (app *Config) CreateUser(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload userPayload
+
+	// Read the request and save it into the payload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Both email and password are needed to create a user
+	if requestPayload.Email == nil || requestPayload.Password == nil {
+		app.errorJSON(w, errors.New("email and password are required"), http.StatusBadRequest)
+		return
+	}
+
+	// New users are active unless told otherwise
+	user := data.User{Active: 1}
+
+	err = applyUserPayload(&user, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validatePassword(*requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	user.Password = *requestPayload.Password
+
+	// Emails must be unique
+	if _, err := app.Repo.GetByEmail(user.Email); err == nil {
+		app.errorJSON(w, errors.New("a user with that email already exists"), http.StatusConflict)
+		return
+	}
+
+	id, err := app.Repo.Insert(user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	user.ID = id
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "created user " + user.Email,
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// GetUser gets a single user by ID
+func (app *Config) GetUser(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userError(w, err)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "user " + user.Email,
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UpdateUser changes a user's email, name, active or admin flags. Passwords
+// are changed with ResetPassword instead
+func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userError(w, err)
+		return
+	}
+
+	var requestPayload userPayload
+
+	// Read the request and save it into the payload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Password != nil {
+		app.errorJSON(w, errors.New("use the reset-password endpoint to change passwords"), http.StatusBadRequest)
+		return
+	}
+
+	previousEmail := user.Email
+
+	err = applyUserPayload(user, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Like DeactivateUser and DeleteUser, admins can't lock themselves out
+	if app.isCurrentUser(r, user.ID) && (user.Active != 1 || user.Admin != 1) {
+		app.errorJSON(w, errors.New("you can't deactivate yourself or remove your own admin access"), http.StatusForbidden)
+		return
+	}
+
+	// Emails must stay unique
+	if user.Email != previousEmail {
+		if _, err := app.Repo.GetByEmail(user.Email); err == nil {
+			app.errorJSON(w, errors.New("a user with that email already exists"), http.StatusConflict)
+			return
+		}
+	}
+
+	err = app.Repo.Update(*user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// A user who can no longer log in shouldn't be able to refresh either
+	if user.Active != 1 {
+		err = app.Repo.RevokeUserRefreshTokens(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "updated user " + user.Email,
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DeactivateUser stops a user from logging in, and revokes their refresh tokens,
+// without deleting them
+func (app *Config) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userError(w, err)
+		return
+	}
+
+	if app.isCurrentUser(r, user.ID) {
+		app.errorJSON(w, errors.New("you can't deactivate yourself"), http.StatusForbidden)
+		return
+	}
+
+	user.Active = 0
+
+	err = app.Repo.Update(*user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Repo.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "deactivated user " + user.Email,
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DeleteUser deletes a user by ID
+func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userError(w, err)
+		return
+	}
+
+	if app.isCurrentUser(r, user.ID) {
+		app.errorJSON(w, errors.New("you can't delete yourself"), http.StatusForbidden)
+		return
+	}
+
+	err = app.Repo.DeleteByID(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "deleted user " + user.Email,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResetPassword sets a new password for a user, and logs them out everywhere
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userError(w, err)
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	// Read the request and save it into the payload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validatePassword(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Repo.ResetPassword(requestPayload.Password, *user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Logins made with the old password shouldn't outlive it
+	err = app.Repo.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "reset password for user " + user.Email,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// userFromURL looks up the user whose ID is in the URL
+func (app *Config) userFromURL(r *http.Request) (*data.User, error) {
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return nil, errors.New("invalid user id")
+	}
+
+	user, err := app.Repo.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// userError sends the right status code for an error from userFromURL
+func (app *Config) userError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUserNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusBadRequest)
+}
+
+// isCurrentUser reports whether the given user ID is the one making the request
+func (app *Config) isCurrentUser(r *http.Request, id int) bool {
+	claims := claimsFromContext(r.Context())
+	return claims != nil && claims.UserID == id
+}
+
+// applyUserPayload copies every field that was given in the payload onto the user,
+// validating them as it goes. The password is left for the caller to deal with
+func applyUserPayload(user *data.User, p userPayload) error {
+
+	if p.Email != nil {
+		email, err := validateEmail(*p.Email)
+		if err != nil {
+			return err
+		}
+		user.Email = email
+	}
+
+	if p.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*p.FirstName)
+	}
+
+	if p.LastName != nil {
+		user.LastName = strings.TrimSpace(*p.LastName)
+	}
+
+	if p.Active != nil {
+		if *p.Active != 0 && *p.Active != 1 {
+			return errors.New("active must be 0 or 1")
+		}
+		user.Active = *p.Active
+	}
+
+	if p.Admin != nil {
+		if *p.Admin != 0 && *p.Admin != 1 {
+			return errors.New("admin must be 0 or 1")
+		}
+		user.Admin = *p.Admin
+	}
+
+	return nil
+}
+
+// validateEmail makes sure the given string is a single, bare email address,
+// and returns it trimmed and lower-cased
+func validateEmail(email string) (string, error) {
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 255 {
+		return "", errors.New("invalid email address")
+	}
+
+	return email, nil
+}
+
+// validatePassword makes sure the given password is a usable length
+func validatePassword(password string) error {
+	if len(password) < MIN_PASSWORD_LENGTH || len(password) > MAX_PASSWORD_LENGTH {
+		return fmt.Errorf("password must be between %d and %d characters", MIN_PASSWORD_LENGTH, MAX_PASSWORD_LENGTH)
+	}
+
+	return nil
+}
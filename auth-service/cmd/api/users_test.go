@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BlackSound1/go-microservices/auth/tokens"
+)
+
+func Test_UserRoutesRequireAdmin(t *testing.T) {
+	admin, _ := testApp.Repo.GetByEmail("me@me.me")
+	adminToken, _, _ := testApp.Tokens.Issue(*admin)
+
+	notAdmin := *admin
+	notAdmin.Admin = 0
+	userToken, _, _ := testApp.Tokens.Issue(notAdmin)
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"bad token", "nonsense", http.StatusUnauthorized},
+		{"not an admin", userToken, http.StatusForbidden},
+		{"admin", adminToken, http.StatusOK},
+	}
+
+	routes := testApp.routes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_UserManagement(t *testing.T) {
+	admin, _ := testApp.Repo.GetByEmail("me@me.me")
+	adminToken, _, _ := testApp.Tokens.Issue(*admin)
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+	}{
+		{"create", "POST", "/users/", `{"email":"new@me.me","password":"password123"}`, http.StatusCreated},
+		{"create with bad email", "POST", "/users/", `{"email":"not an email","password":"password123"}`, http.StatusBadRequest},
+		{"create with short password", "POST", "/users/", `{"email":"new@me.me","password":"short"}`, http.StatusBadRequest},
+		{"create with taken email", "POST", "/users/", `{"email":"me@me.me","password":"password123"}`, http.StatusConflict},
+		{"get", "GET", "/users/1", "", http.StatusOK},
+		{"get with bad id", "GET", "/users/abc", "", http.StatusBadRequest},
+		{"update", "PUT", "/users/2", `{"first_name":"New"}`, http.StatusAccepted},
+		{"update password", "PUT", "/users/2", `{"password":"password123"}`, http.StatusBadRequest},
+		{"update bad active", "PUT", "/users/2", `{"active":5}`, http.StatusBadRequest},
+		{"update self inactive", "PUT", "/users/1", `{"active":0}`, http.StatusForbidden},
+		{"update self not admin", "PUT", "/users/1", `{"admin":0}`, http.StatusForbidden},
+		{"update self", "PUT", "/users/1", `{"first_name":"Me"}`, http.StatusAccepted},
+		{"deactivate", "POST", "/users/2/deactivate", "", http.StatusAccepted},
+		{"deactivate self", "POST", "/users/1/deactivate", "", http.StatusForbidden},
+		{"reset password", "POST", "/users/2/reset-password", `{"password":"password123"}`, http.StatusAccepted},
+		{"reset short password", "POST", "/users/2/reset-password", `{"password":"short"}`, http.StatusBadRequest},
+		{"delete", "DELETE", "/users/2", "", http.StatusAccepted},
+		{"delete self", "DELETE", "/users/1", "", http.StatusForbidden},
+	}
+
+	routes := testApp.routes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, bytes.NewBufferString(e.body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedCode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func Test_validateEmail(t *testing.T) {
+	tests := map[string]bool{
+		"me@me.me":           true,
+		"  Me@Example.COM  ": true,
+		"":                   false,
+		"not an email":       false,
+		"Me <me@me.me>":      false,
+		"me@":                false,
+	}
+
+	for email, valid := range tests {
+		_, err := validateEmail(email)
+		if (err == nil) != valid {
+			t.Errorf("%q: expected valid=%t but got %v", email, valid, err)
+		}
+	}
+}
+
+func Test_DeactivateRevokesRefreshTokens(t *testing.T) {
+	admin, _ := testApp.Repo.GetByEmail("me@me.me")
+	adminToken, _, _ := testApp.Tokens.Issue(*admin)
+
+	user, _ := testApp.Repo.GetByID(2)
+
+	login, err := testApp.issueTokens(*user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/users/2/deactivate", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	rr := httptest.NewRecorder()
+	testApp.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected http.StatusAccepted but got %d", rr.Code)
+	}
+
+	stored, _ := testApp.Repo.GetRefreshTokenByHash(tokens.HashRefreshToken(login.RefreshToken))
+	if stored.RevokedAt == nil {
+		t.Error("expected the user's refresh tokens to be revoked")
+	}
+}
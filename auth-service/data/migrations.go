@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// migrations bring databases created from older versions of db_creation_script.sql
+// up to date. Each one must be safe to run again, since they all run on every start
+var migrations = []string{
+	// Admin flags, for managing users through the API
+	`ALTER TABLE public.users ADD COLUMN IF NOT EXISTS user_admin INTEGER DEFAULT 0`,
+
+	// Refresh tokens, which /authenticate stores and /refresh rotates
+	`CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+		token_hash CHARACTER(64) NOT NULL UNIQUE,
+		family_id CHARACTER varying(64) NOT NULL,
+		expires_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITHOUT TIME ZONE,
+		replaced_by INTEGER REFERENCES public.refresh_tokens (id),
+		created_at TIMESTAMP WITHOUT TIME ZONE
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens (family_id)`,
+}
+
+// Migrate runs every migration against the given database
+func Migrate(conn *sql.DB) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	for _, stmt := range migrations {
+		_, err := conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
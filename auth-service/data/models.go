@@ -12,6 +12,10 @@ import (
 
 const DB_TIMEOUT = time.Second * 3
 
+// ErrRefreshTokenRevoked is returned when rotating a refresh token that has
+// already been used or revoked
+var ErrRefreshTokenRevoked = errors.New("refresh token has already been used or revoked")
+
 var db *sql.DB
 
 type PostgresRepository struct {
@@ -43,10 +47,26 @@ type User struct {
 	LastName  string    `json:"last_name,omitempty"`
 	Password  string    `json:"-"`
 	Active    int       `json:"active"`
+	Admin     int       `json:"admin"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// RefreshToken holds a refresh token from the database.
+//
+// Only a hash of the token itself is ever stored. Every token issued from the same
+// login shares a FamilyID, so the whole chain can be revoked at once.
+type RefreshToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	TokenHash  string     `json:"-"`
+	FamilyID   string     `json:"family_id"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy *int       `json:"replaced_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
 // GetAll gets all users from the database.
 func (u *PostgresRepository) GetAll() ([]*User, error) {
 
@@ -56,7 +76,7 @@ func (u *PostgresRepository) GetAll() ([]*User, error) {
 
 	query := `
 		SELECT 
-			id, email, first_name, last_name, password, user_active, created_at, updated_at
+			id, email, first_name, last_name, password, user_active, user_admin, created_at, updated_at
 		FROM
 			public.users
 		ORDER BY
@@ -81,6 +101,7 @@ func (u *PostgresRepository) GetAll() ([]*User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.Active,
+			&user.Admin,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -102,7 +123,7 @@ func (u *PostgresRepository) GetByEmail(email string) (*User, error) {
 
 	query := `
 		SELECT 
-			id, email, first_name, last_name, password, user_active, created_at, updated_at
+			id, email, first_name, last_name, password, user_active, user_admin, created_at, updated_at
 		FROM
 			public.users
 		WHERE
@@ -119,6 +140,7 @@ func (u *PostgresRepository) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Admin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -138,7 +160,7 @@ func (u *PostgresRepository) GetByID(id int) (*User, error) {
 
 	query := `
 		SELECT
-			id, email, first_name, last_name, password, user_active, created_at, updated_at
+			id, email, first_name, last_name, password, user_active, user_admin, created_at, updated_at
 		FROM
 			public.users
 		WHERE
@@ -155,6 +177,7 @@ func (u *PostgresRepository) GetByID(id int) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Admin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -180,9 +203,10 @@ func (u *PostgresRepository) Update(user User) error {
 			first_name = $2,
 			last_name = $3,
 			user_active = $4,
-			updated_at = $5
+			user_admin = $5,
+			updated_at = $6
 		WHERE
-			id = $6
+			id = $7
 	`
 
 	_, err := db.ExecContext(
@@ -192,6 +216,7 @@ func (u *PostgresRepository) Update(user User) error {
 		user.FirstName,
 		user.LastName,
 		user.Active,
+		user.Admin,
 		time.Now(),
 		user.ID,
 	)
@@ -243,9 +268,9 @@ func (u *PostgresRepository) Insert(user User) (int, error) {
 	stmt := `
 		INSERT INTO
 			public.users 
-				(email, first_name, last_name, password, user_active, created_at, updated_at)
+				(email, first_name, last_name, password, user_active, user_admin, created_at, updated_at)
 		VALUES
-			($1, $2, $3, $4, $5, $6, $7)
+			($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
 	`
 
@@ -257,6 +282,7 @@ func (u *PostgresRepository) Insert(user User) (int, error) {
 		user.LastName,
 		hashedPassword,
 		user.Active,
+		user.Admin,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -312,3 +338,201 @@ func (u *PostgresRepository) PasswordMatches(plainText string, user User) (bool,
 	}
 	return true, nil
 }
+
+// InsertRefreshToken stores a new refresh token, and returns the ID of the newly created token.
+func (u *PostgresRepository) InsertRefreshToken(token RefreshToken) (int, error) {
+
+	// To avoid long queries
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	return insertRefreshToken(ctx, db, token)
+}
+
+// GetRefreshTokenByHash gets a refresh token by the hash of the token.
+func (u *PostgresRepository) GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+
+	// To avoid long queries
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, user_id, token_hash, family_id, expires_at, revoked_at, replaced_by, created_at
+		FROM
+			public.refresh_tokens
+		WHERE
+			token_hash = $1
+	`
+
+	var token RefreshToken
+	var revokedAt sql.NullTime
+	var replacedBy sql.NullInt64
+
+	row := db.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&revokedAt,
+		&replacedBy,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	if replacedBy.Valid {
+		id := int(replacedBy.Int64)
+		token.ReplacedBy = &id
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken stores the replacement token and revokes the old one in a single
+// transaction, and returns the ID of the replacement.
+//
+// If the old token has already been used or revoked, nothing is changed and
+// ErrRefreshTokenRevoked is returned.
+func (u *PostgresRepository) RotateRefreshToken(oldID int, replacement RefreshToken) (int, error) {
+
+	// To avoid long queries
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertRefreshToken(ctx, tx, replacement)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only revoke the old token if nobody else got to it first
+	stmt := `
+		UPDATE
+			public.refresh_tokens
+		SET
+			revoked_at = $1,
+			replaced_by = $2
+		WHERE
+			id = $3 AND revoked_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), newID, oldID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rows == 0 {
+		return 0, ErrRefreshTokenRevoked
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in the given family that
+// hasn't been revoked already.
+func (u *PostgresRepository) RevokeRefreshTokenFamily(familyID string) error {
+
+	// To avoid long queries
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	stmt := `
+		UPDATE
+			public.refresh_tokens
+		SET
+			revoked_at = $1
+		WHERE
+			family_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token belonging to the given user that
+// hasn't been revoked already, logging them out everywhere.
+func (u *PostgresRepository) RevokeUserRefreshTokens(userID int) error {
+
+	// To avoid long queries
+	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT)
+	defer cancel()
+
+	stmt := `
+		UPDATE
+			public.refresh_tokens
+		SET
+			revoked_at = $1
+		WHERE
+			user_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertRefreshToken stores a new refresh token using the given database or transaction.
+func insertRefreshToken(ctx context.Context, q queryRower, token RefreshToken) (int, error) {
+
+	var newID int
+
+	stmt := `
+		INSERT INTO
+			public.refresh_tokens
+				(user_id, token_hash, family_id, expires_at, created_at)
+		VALUES
+			($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := q.QueryRowContext(
+		ctx,
+		stmt,
+		token.UserID,
+		token.TokenHash,
+		token.FamilyID,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
@@ -9,4 +9,9 @@ type Repository interface {
 	Insert(user User) (int, error)
 	ResetPassword(password string, user User) error
 	PasswordMatches(plainText string, user User) (bool, error)
+	InsertRefreshToken(token RefreshToken) (int, error)
+	GetRefreshTokenByHash(hash string) (*RefreshToken, error)
+	RotateRefreshToken(oldID int, replacement RefreshToken) (int, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
 }
@@ -2,15 +2,24 @@ package data
 
 import (
 	"database/sql"
+	"sync"
 	"time"
 )
 
 type PostgresTestRepository struct {
 	Conn *sql.DB
+
+	// Refresh tokens are kept in memory, so the token flows can be tested end to end
+	mu            sync.Mutex
+	refreshTokens map[int]*RefreshToken
+	lastTokenID   int
 }
 
 func NewPostgresTestRepository(db *sql.DB) *PostgresTestRepository {
-	return &PostgresTestRepository{Conn: db}
+	return &PostgresTestRepository{
+		Conn:          db,
+		refreshTokens: make(map[int]*RefreshToken),
+	}
 }
 
 // GetAll gets all users from the database.
@@ -19,8 +28,12 @@ func (u *PostgresTestRepository) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-// GetByEmail gets a user by email.
+// GetByEmail gets a user by email. Only the test user, me@me.me, exists.
 func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
+	if email != "me@me.me" {
+		return nil, sql.ErrNoRows
+	}
+
 	user := User{
 		ID:        1,
 		FirstName: "First",
@@ -28,6 +41,7 @@ func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
 		Email:     "me@me.me",
 		Password:  "",
 		Active:    1,
+		Admin:     1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -38,12 +52,13 @@ func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
 // GetByID gets a user by ID.
 func (u *PostgresTestRepository) GetByID(id int) (*User, error) {
 	user := User{
-		ID:        1,
+		ID:        id,
 		FirstName: "First",
 		LastName:  "Last",
 		Email:     "me@me.me",
 		Password:  "",
 		Active:    1,
+		Admin:     1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -79,3 +94,95 @@ func (u *PostgresTestRepository) ResetPassword(password string, user User) error
 func (u *PostgresTestRepository) PasswordMatches(plainText string, user User) (bool, error) {
 	return true, nil
 }
+
+// InsertRefreshToken stores a new refresh token, and returns the ID of the newly created token.
+func (u *PostgresTestRepository) InsertRefreshToken(token RefreshToken) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.insertRefreshToken(token), nil
+}
+
+// GetRefreshTokenByHash gets a refresh token by the hash of the token.
+func (u *PostgresTestRepository) GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, token := range u.refreshTokens {
+		if token.TokenHash == hash {
+			found := *token
+			return &found, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// RotateRefreshToken stores the replacement token and revokes the old one, and returns
+// the ID of the replacement.
+//
+// If the old token has already been used or revoked, nothing is changed and
+// ErrRefreshTokenRevoked is returned.
+func (u *PostgresTestRepository) RotateRefreshToken(oldID int, replacement RefreshToken) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	old, ok := u.refreshTokens[oldID]
+	if !ok || old.RevokedAt != nil {
+		return 0, ErrRefreshTokenRevoked
+	}
+
+	newID := u.insertRefreshToken(replacement)
+
+	now := time.Now()
+	old.RevokedAt = &now
+	old.ReplacedBy = &newID
+
+	return newID, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in the given family that
+// hasn't been revoked already.
+func (u *PostgresTestRepository) RevokeRefreshTokenFamily(familyID string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range u.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token belonging to the given user that
+// hasn't been revoked already, logging them out everywhere.
+func (u *PostgresTestRepository) RevokeUserRefreshTokens(userID int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range u.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// insertRefreshToken stores a token in memory. The caller must hold u.mu
+func (u *PostgresTestRepository) insertRefreshToken(token RefreshToken) int {
+	if u.refreshTokens == nil {
+		u.refreshTokens = make(map[int]*RefreshToken)
+	}
+
+	u.lastTokenID++
+	token.ID = u.lastTokenID
+	token.CreatedAt = time.Now()
+	u.refreshTokens[token.ID] = &token
+
+	return token.ID
+}
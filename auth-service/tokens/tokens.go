@@ -0,0 +1,230 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/auth/data"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DEFAULT_ACCESS_TTL  = 15 * time.Minute
+	DEFAULT_REFRESH_TTL = 30 * 24 * time.Hour
+	MIN_SECRET_LENGTH   = 32 // How long an HS256 secret must be
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Config holds everything needed to sign and verify access tokens
+type Config struct {
+	Algorithm  string          // "HS256" or "RS256"
+	Secret     []byte          // Shared secret, used with HS256
+	PrivateKey *rsa.PrivateKey // Signing key, used with RS256. Its public half verifies tokens
+	Issuer     string          // Optional "iss" claim, checked on verification if set
+	Audience   string          // Optional "aud" claim, checked on verification if set
+	AccessTTL  time.Duration   // How long an access token is valid for
+	RefreshTTL time.Duration   // How long a refresh token is valid for
+}
+
+// Claims are the claims carried by every access token
+type Claims struct {
+	UserID int    `json:"uid"`
+	Email  string `json:"email"`
+	Active bool   `json:"active"`
+	Admin  bool   `json:"admin"`
+	jwt.RegisteredClaims
+}
+
+// Manager issues and verifies signed access tokens
+type Manager struct {
+	config    Config
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// NewManager creates a Manager from the given Config, making sure the
+// algorithm is supported and that it has the keys that algorithm needs
+func NewManager(config Config) (*Manager, error) {
+
+	if config.AccessTTL <= 0 {
+		config.AccessTTL = DEFAULT_ACCESS_TTL
+	}
+
+	if config.RefreshTTL <= 0 {
+		config.RefreshTTL = DEFAULT_REFRESH_TTL
+	}
+
+	m := Manager{config: config}
+
+	switch config.Algorithm {
+	case "HS256", "":
+		err := checkSecret(config.Secret)
+		if err != nil {
+			return nil, err
+		}
+		m.config.Algorithm = "HS256"
+		m.method = jwt.SigningMethodHS256
+		m.signKey = config.Secret
+		m.verifyKey = config.Secret
+	case "RS256":
+		if config.PrivateKey == nil {
+			return nil, errors.New("RS256 tokens need a private key")
+		}
+		m.method = jwt.SigningMethodRS256
+		m.signKey = config.PrivateKey
+		m.verifyKey = &config.PrivateKey.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", config.Algorithm)
+	}
+
+	return &m, nil
+}
+
+// checkSecret refuses HS256 secrets that are missing, too short to be hard to guess,
+// or are obviously placeholders someone forgot to replace. Anyone who knows the
+// secret can sign their own tokens
+func checkSecret(secret []byte) error {
+
+	if len(secret) == 0 {
+		return errors.New("HS256 tokens need a secret")
+	}
+
+	if len(secret) < MIN_SECRET_LENGTH {
+		return fmt.Errorf("HS256 secrets must be at least %d characters long", MIN_SECRET_LENGTH)
+	}
+
+	lower := strings.ToLower(string(secret))
+	for _, placeholder := range []string{"change-me", "changeme", "placeholder", "example"} {
+		if strings.Contains(lower, placeholder) {
+			return errors.New("the HS256 secret looks like a placeholder, set it to a random value")
+		}
+	}
+
+	return nil
+}
+
+// Issue creates a signed access token for the given user, and returns it
+// along with the time it expires
+func (m *Manager) Issue(user data.User) (string, time.Time, error) {
+
+	now := time.Now()
+	expiresAt := now.Add(m.config.AccessTTL)
+
+	claims := Claims{
+		UserID: user.ID,
+		Email:  user.Email,
+		Active: user.Active == 1,
+		Admin:  user.Admin == 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Issuer:    m.config.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	if m.config.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{m.config.Audience}
+	}
+
+	// Sign the token
+	signed, err := jwt.NewWithClaims(m.method, claims).SignedString(m.signKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
+}
+
+// Verify checks the given token's signature, algorithm and registered claims,
+// and returns its claims if it is valid
+func (m *Manager) Verify(token string) (*Claims, error) {
+
+	// Only accept the algorithm we sign with, so a token can't pick its own
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{m.config.Algorithm}),
+		jwt.WithExpirationRequired(),
+	}
+
+	if m.config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(m.config.Issuer))
+	}
+
+	if m.config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(m.config.Audience))
+	}
+
+	var claims Claims
+	parsed, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return m.verifyKey, nil
+	}, opts...)
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// RefreshTTL returns how long refresh tokens issued by this Manager are valid for
+func (m *Manager) RefreshTTL() time.Duration {
+	return m.config.RefreshTTL
+}
+
+// NewRefreshToken generates a new opaque refresh token, and returns it along with
+// the hash that should be stored in its place
+func NewRefreshToken() (string, string, error) {
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash a refresh token is stored and looked up by
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFamilyID generates an ID for a new family of refresh tokens
+func NewFamilyID() (string, error) {
+	return randomString(16)
+}
+
+// randomString returns n random bytes, encoded as URL-safe base64
+func randomString(n int) (string, error) {
+
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// LoadRSAPrivateKey reads a PEM-encoded RSA private key from the given file
+func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseRSAPrivateKeyFromPEM(pem)
+}
@@ -0,0 +1,102 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/auth/data"
+)
+
+// The secrets tokens are signed with in tests
+const (
+	testSecret  = "q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf"
+	otherSecret = "Zr4Lm8Qw2Nc6Xv0Bt5Hy9Jk3Pd7Gs1Fa"
+)
+
+func Test_IssueAndVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := map[string]Config{
+		"HS256": {Algorithm: "HS256", Secret: []byte(testSecret), Issuer: "auth-service", Audience: "broker"},
+		"RS256": {Algorithm: "RS256", PrivateKey: key},
+	}
+
+	user := data.User{ID: 7, Email: "me@me.me", Active: 1}
+
+	for name, config := range configs {
+		m, err := NewManager(config)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		token, expiresAt, err := m.Issue(user)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if time.Until(expiresAt) > DEFAULT_ACCESS_TTL || time.Until(expiresAt) <= 0 {
+			t.Errorf("%s: unexpected expiry %v", name, expiresAt)
+		}
+
+		claims, err := m.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if claims.UserID != 7 || claims.Email != "me@me.me" || !claims.Active || claims.Subject != "7" {
+			t.Errorf("%s: unexpected claims %+v", name, claims)
+		}
+	}
+}
+
+func Test_VerifyRejects(t *testing.T) {
+	m, _ := NewManager(Config{Secret: []byte(testSecret), AccessTTL: time.Minute})
+	other, _ := NewManager(Config{Secret: []byte(otherSecret)})
+	expired, _ := NewManager(Config{Secret: []byte(testSecret), AccessTTL: time.Nanosecond})
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaManager, _ := NewManager(Config{Algorithm: "RS256", PrivateKey: key})
+
+	user := data.User{ID: 1, Email: "me@me.me"}
+
+	wrongKey, _, _ := other.Issue(user)
+	wrongAlg, _, _ := rsaManager.Issue(user)
+	stale, _, _ := expired.Issue(user)
+	time.Sleep(time.Millisecond)
+
+	for name, token := range map[string]string{
+		"garbage":   "not.a.token",
+		"wrong key": wrongKey,
+		"wrong alg": wrongAlg,
+		"expired":   stale,
+	} {
+		if _, err := m.Verify(token); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken but got %v", name, err)
+		}
+	}
+}
+
+func Test_NewManager(t *testing.T) {
+	if _, err := NewManager(Config{Algorithm: "HS256"}); err == nil {
+		t.Error("expected an error for HS256 without a secret")
+	}
+
+	for _, secret := range []string{"secret", "change-me-in-production-and-make-it-longer"} {
+		if _, err := NewManager(Config{Algorithm: "HS256", Secret: []byte(secret)}); err == nil {
+			t.Errorf("expected an error for the HS256 secret %q", secret)
+		}
+	}
+
+	if _, err := NewManager(Config{Algorithm: "RS256"}); err == nil {
+		t.Error("expected an error for RS256 without a key")
+	}
+
+	if _, err := NewManager(Config{Algorithm: "none", Secret: []byte("x")}); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
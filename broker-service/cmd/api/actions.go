@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RequestPayload is the body every request to /handle is expected to have.
+//
+// The "action" key picks the action to run. Every other top-level key is kept
+// as raw JSON, so each action can decode the payload it registered for itself
+type RequestPayload struct {
+	Action string
+	Fields map[string]json.RawMessage
+}
+
+// UnmarshalJSON splits the request into its action name and its raw payloads
+func (p *RequestPayload) UnmarshalJSON(b []byte) error {
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+
+	// The action itself must be a plain string
+	if raw, ok := fields["action"]; ok {
+		err = json.Unmarshal(raw, &p.Action)
+		if err != nil {
+			return errors.New("action must be a string")
+		}
+		delete(fields, "action")
+	}
+
+	p.Fields = fields
+
+	return nil
+}
+
+// ActionHandler handles an action once its payload has been decoded and validated
+type ActionHandler func(w http.ResponseWriter, r *http.Request, payload any)
+
+// Action describes a single action the broker knows how to handle
+type Action struct {
+	Name     string                                 // Name is what callers put in the "action" key
+	Key      string                                 // Key is the request key the payload lives under
+	Decode   func(raw json.RawMessage) (any, error) // Decode turns the raw payload into a typed value
+	Validate func(payload any) error                // Validate checks the decoded payload before handling it
+	Handle   ActionHandler                          // Handle performs the action
+	Schema   map[string]string                      // Schema describes the payload's fields, for /actions
+}
+
+// ActionInfo is what GET /actions reports about each registered action
+type ActionInfo struct {
+	Name   string            `json:"name"`
+	Key    string            `json:"key"`
+	Schema map[string]string `json:"schema"`
+}
+
+// ActionRegistry holds every action the broker can handle, keyed by name
+type ActionRegistry struct {
+	mu      sync.RWMutex
+	actions map[string]Action
+}
+
+// NewActionRegistry returns an empty ActionRegistry
+func NewActionRegistry() *ActionRegistry {
+	return &ActionRegistry{actions: make(map[string]Action)}
+}
+
+// Register adds the given action to the registry. It returns an error if the action
+// has no name or handler, or if an action with the same name is already registered
+func (reg *ActionRegistry) Register(a Action) error {
+
+	if a.Name == "" {
+		return errors.New("action must have a name")
+	}
+
+	if a.Handle == nil || a.Decode == nil {
+		return fmt.Errorf("action %q must have a decoder and a handler", a.Name)
+	}
+
+	// By default, the payload lives under the action's own name
+	if a.Key == "" {
+		a.Key = a.Name
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, exists := reg.actions[a.Name]; exists {
+		return fmt.Errorf("action %q is already registered", a.Name)
+	}
+
+	reg.actions[a.Name] = a
+
+	return nil
+}
+
+// Get looks up a registered action by name
+func (reg *ActionRegistry) Get(name string) (Action, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	a, ok := reg.actions[name]
+	return a, ok
+}
+
+// List returns a description of every registered action, sorted by name
+func (reg *ActionRegistry) List() []ActionInfo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	infos := make([]ActionInfo, 0, len(reg.actions))
+	for _, a := range reg.actions {
+		infos = append(infos, ActionInfo{Name: a.Name, Key: a.Key, Schema: a.Schema})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// NewAction builds an Action for a payload of type T. The decoder, the schema and the
+// type assertions between the generic registry and the typed validator and handler are
+// all derived from T, so callers only have to write the typed parts.
+//
+// validate may be nil if the payload needs no validation
+func NewAction[T any](name, key string, validate func(T) error, handle func(w http.ResponseWriter, r *http.Request, payload T)) Action {
+
+	var zero T
+
+	return Action{
+		Name: name,
+		Key:  key,
+		Decode: func(raw json.RawMessage) (any, error) {
+			var payload T
+
+			// A missing payload decodes to the zero value, and is left to the validator
+			if len(raw) == 0 {
+				return payload, nil
+			}
+
+			err := json.Unmarshal(raw, &payload)
+			if err != nil {
+				return nil, err
+			}
+
+			return payload, nil
+		},
+		Validate: func(payload any) error {
+			if validate == nil {
+				return nil
+			}
+			return validate(payload.(T))
+		},
+		Handle: func(w http.ResponseWriter, r *http.Request, payload any) {
+			handle(w, r, payload.(T))
+		},
+		Schema: payloadSchema(reflect.TypeOf(zero)),
+	}
+}
+
+// Dispatch decodes, validates and handles the given request using the action it names
+func (reg *ActionRegistry) Dispatch(w http.ResponseWriter, r *http.Request, req RequestPayload) error {
+
+	a, ok := reg.Get(req.Action)
+	if !ok {
+		return errors.New("unknown action")
+	}
+
+	payload, err := a.Decode(req.Fields[a.Key])
+	if err != nil {
+		return fmt.Errorf("invalid %s payload: %w", a.Name, err)
+	}
+
+	if a.Validate != nil {
+		err = a.Validate(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.Handle(w, r, payload)
+
+	return nil
+}
+
+// payloadSchema describes the JSON fields of the given struct type as a map of
+// field name to type name, e.g. {"email": "string"}
+func payloadSchema(t reflect.Type) map[string]string {
+
+	schema := make(map[string]string)
+
+	if t == nil {
+		return schema
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return schema
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		// Use the JSON name if there is one, and skip ignored fields
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		schema[name] = schemaType(field.Type)
+	}
+
+	return schema
+}
+
+// schemaType returns a short, JSON-flavoured name for the given type
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "[]" + schemaType(t.Elem())
+	case reflect.Map, reflect.Struct, reflect.Interface:
+		return "object"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/broker/outbox"
+)
+
+func Test_ActionRegistry_Dispatch(t *testing.T) {
+	reg := NewActionRegistry()
+
+	var got AuthPayload
+	err := reg.Register(NewAction("auth", "auth", validateAuthPayload, func(w http.ResponseWriter, r *http.Request, a AuthPayload) {
+		got = a
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"valid", `{"action":"auth","auth":{"email":"me@me.me","password":"secret"}}`, ""},
+		{"unknown action", `{"action":"nope"}`, "unknown action"},
+		{"missing payload", `{"action":"auth"}`, "email and password are required"},
+		{"bad payload", `{"action":"auth","auth":"oops"}`, "invalid auth payload"},
+	}
+
+	for _, tt := range tests {
+		var req RequestPayload
+		err := json.Unmarshal([]byte(tt.body), &req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		rr := httptest.NewRecorder()
+		err = reg.Dispatch(rr, httptest.NewRequest("POST", "/handle", nil), req)
+
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			if rr.Code != http.StatusAccepted || got.Email != "me@me.me" {
+				t.Errorf("%s: handler was not called with the decoded payload", tt.name)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q but got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func Test_ActionRegistry_Register(t *testing.T) {
+	reg := NewActionRegistry()
+
+	noop := func(w http.ResponseWriter, r *http.Request, l LogPayload) {}
+
+	if err := reg.Register(NewAction("log", "", nil, noop)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reg.Register(NewAction("log", "", nil, noop)); err == nil {
+		t.Error("expected an error registering a duplicate action")
+	}
+
+	if err := reg.Register(Action{Name: "empty"}); err == nil {
+		t.Error("expected an error registering an action without a handler")
+	}
+
+	infos := reg.List()
+	if len(infos) != 1 || infos[0].Key != "log" {
+		t.Fatalf("expected the log action keyed under \"log\" but got %+v", infos)
+	}
+
+	if infos[0].Schema["name"] != "string" || infos[0].Schema["data"] != "string" {
+		t.Errorf("unexpected schema %v", infos[0].Schema)
+	}
+}
+
+func Test_HandleSubmission_Size(t *testing.T) {
+	app := Config{Actions: NewActionRegistry()}
+
+	accept := func(w http.ResponseWriter, r *http.Request, l LogPayload) { w.WriteHeader(http.StatusAccepted) }
+	for _, name := range []string{"log", "mail"} {
+		if err := app.Actions.Register(NewAction(name, "log", nil, accept)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	big := strings.Repeat("x", MAX_REQUEST_SIZE)
+
+	// Only actions that carry attachments may be bigger than the usual limit
+	for action, want := range map[string]int{"log": http.StatusRequestEntityTooLarge, "mail": http.StatusAccepted} {
+		body := `{"action":"` + action + `","log":{"name":"big","data":"` + big + `"}}`
+
+		rr := httptest.NewRecorder()
+		app.HandleSubmission(rr, httptest.NewRequest("POST", "/handle", strings.NewReader(body)))
+
+		if rr.Code != want {
+			t.Errorf("%s: expected %d but got %d", action, want, rr.Code)
+		}
+	}
+}
+
+func Test_PublishLogin(t *testing.T) {
+	store, err := outbox.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Config{Outbox: store, Relay: outbox.NewRelay(store, nil)}
+
+	// The auth service's response, as it was decoded
+	app.publishLogin("admin@example.com", map[string]any{"user": map[string]any{"id": 1.0, "email": "admin@example.com"}})
+
+	due, err := store.Due(time.Now(), 10)
+	if err != nil || len(due) != 1 || due[0].RoutingKey != "auth.login" {
+		t.Fatalf("expected a login event in the outbox but got %+v (%v)", due, err)
+	}
+
+	auth, err := due[0].Envelope.AuthData()
+	if err != nil || auth.Action != "login" || auth.UserID != 1 || auth.Email != "admin@example.com" {
+		t.Errorf("expected the admin's login but got %+v (%v)", auth, err)
+	}
+}
@@ -3,39 +3,121 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"net/rpc"
-	"time"
+	"strings"
 
 	"github.com/BlackSound1/go-microservices/broker/logs"
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/httputil"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
-type RequestPayload struct {
-	Action string      `json:"action"`
-	Auth   AuthPayload `json:"auth,omitempty"`
-	Log    LogPayload  `json:"log,omitempty"`
-	Mail   MailPayload `json:"mail,omitempty"`
-}
-
 type AuthPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+// STREAM_THRESHOLD is the largest log batch sent in a single WriteLogs call
+const STREAM_THRESHOLD = 500
+
+// LogPayload is a log entry to send to the logger service. Only the name is
+// required, and everything after the data is optional
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Level      string         `json:"level,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
+// MailPayload is a mail to send. It must have the same fields as events.MailData, so
+// one can be converted to the other. To, CC and BCC can each be a single address or a list
 type MailPayload struct {
-	From    string `json:"from"`
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Message string `json:"message"`
+	From            string              `json:"from"`
+	To              events.Addresses    `json:"to"`
+	CC              events.Addresses    `json:"cc,omitempty"`
+	BCC             events.Addresses    `json:"bcc,omitempty"`
+	ReplyTo         string              `json:"reply_to,omitempty"`
+	Headers         map[string]string   `json:"headers,omitempty"`
+	Subject         string              `json:"subject"`
+	Message         string              `json:"message"`
+	Template        string              `json:"template,omitempty"`
+	TemplateVersion int                 `json:"template_version,omitempty"`
+	Data            map[string]any      `json:"data,omitempty"`
+	Attachments     []events.Attachment `json:"attachments,omitempty"`
+}
+
+// requestSizes is how big requests for the actions that carry attachments may be.
+// Every other action's requests must fit in MAX_REQUEST_SIZE
+var requestSizes = map[string]int64{
+	"mail":       events.MAX_MAIL_REQUEST_SIZE,
+	"mail.queue": events.MAX_QUEUED_MAIL_REQUEST_SIZE,
+}
+
+// registerActions registers every action the broker supports with the action registry
+func (app *Config) registerActions() error {
+
+	actions := []Action{
+		NewAction("auth", "auth", nil, app.authenticate),
+		NewAction("log", "log", validateLogPayload, func(w http.ResponseWriter, r *http.Request, l LogPayload) {
+			app.logItemViaRPC(w, l)
+			// app.logItem(w, l)
+		}),
+		NewAction("log.queue", "log", validateLogPayload, app.logEventViaRabbit),
+		NewAction("log.batch", "logs", validateLogBatchPayload, app.logBatchViaGRPC),
+		NewAction("mail", "mail", validateMailPayload, app.sendMail),
+		NewAction("mail.queue", "mail", validateMailPayload, app.queueMail),
+	}
+
+	// Manage users through the auth service
+	actions = append(actions, app.userActions()...)
+
+	// Manage the mail service's suppression list
+	actions = append(actions, app.suppressionActions()...)
+
+	for _, a := range actions {
+		err := app.Actions.Register(a)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateAuthPayload makes sure both credentials were given. It is only
+// needed when the request doesn't carry a bearer token instead
+func validateAuthPayload(a AuthPayload) error {
+	if a.Email == "" || a.Password == "" {
+		return errors.New("email and password are required")
+	}
+	return nil
+}
+
+// validateLogPayload makes sure the log entry has a name, and a level the logger
+// service knows if it has one
+func validateLogPayload(l LogPayload) error {
+	if l.Name == "" {
+		return errors.New("log name is required")
+	}
+
+	_, err := events.NormalizeLevel(l.Level)
+
+	return err
+}
+
+// validateMailPayload makes sure the mail has somewhere to go, and that every
+// address and header in it is valid
+func validateMailPayload(m MailPayload) error {
+	return events.MailData(m).Validate()
 }
 
 // Broker handles the broker service, returning a simple JSON message
@@ -53,69 +135,118 @@ func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
 // HandleSubmission handles any incoming requests to the broker service
 //
 // It expects to receive a JSON payload with an "action" parameter, which
-// determines the action to take. The following actions are currently
-// supported:
-//
-// - "auth": Authenticate the user using the credentials provided in the request body
+// determines the action to take. The action is looked up in the action
+// registry, which decodes and validates its payload before handling it.
+// GET /actions lists the actions that are currently registered.
 //
 // Any other action will result in an error response being sent
 func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 
 	var requestPayload RequestPayload
 
-	// Read the JSON from the request
-	err := app.readJSON(w, r, &requestPayload)
+	// Read the JSON from the request. It can be big, since mails carry their
+	// attachments, but only mail actions may be bigger than the usual limit
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+
+	err := app.readJSON(w, r, &requestPayload, events.MAX_MAIL_REQUEST_SIZE)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// Different behaviour depending on the action specified
-	switch requestPayload.Action {
-	case "auth":
-		app.authenticate(w, requestPayload.Auth)
-	case "log":
-		app.logItemViaRPC(w, requestPayload.Log)
-		// app.logEventViaRabbit(w, requestPayload.Log)
-		// app.logItem(w, requestPayload.Log)
-	case "mail":
-		app.sendMail(w, requestPayload.Mail)
-	default:
-		app.errorJSON(w, errors.New("unknown action"))
+	maxBytes, ok := requestSizes[requestPayload.Action]
+	if !ok {
+		maxBytes = MAX_REQUEST_SIZE
 	}
-}
-
-// sendMail sends an email by forwarding the MailPayload to the mail service.
-func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
-
-	jsonData, _ := json.MarshalIndent(msg, "", "\t")
 
-	mailServiceURL := "http://mail-service/send"
+	if body.n > maxBytes {
+		app.errorJSON(w, fmt.Errorf("request body must not be larger than %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	req, err := http.NewRequest("POST", mailServiceURL, bytes.NewBuffer(jsonData))
+	// Hand the request off to whichever action it names
+	err = app.Actions.Dispatch(w, r, requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// ListActions lists every registered action along with the schema of its payload
+func (app *Config) ListActions(w http.ResponseWriter, r *http.Request) {
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	payload := JSONResponse{
+		Error:   false,
+		Message: "available actions",
+		Data:    app.Actions.List(),
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Health reports the state of the broker's connection to RabbitMQ, and how many
+// events are waiting in the outbox. It responds with 503 Service Unavailable while
+// the connection is down
+func (app *Config) Health(w http.ResponseWriter, r *http.Request) {
+
+	status := app.Rabbit.Status()
+
+	data := map[string]any{"rabbitmq": status}
+
+	// Events waiting to be published pile up while RabbitMQ is down
+	pending, err := app.Outbox.Len()
+	if err == nil {
+		data["outbox_pending"] = pending
+	}
+
+	payload := JSONResponse{
+		Error:   !status.Connected,
+		Message: "rabbitmq " + status.State,
+		Data:    data,
+	}
+
+	code := http.StatusOK
+	if !status.Connected {
+		code = http.StatusServiceUnavailable
+	}
+
+	_ = app.writeJSON(w, code, payload)
+}
+
+// sendMail sends an email by forwarding the MailPayload to the mail service. The mail
+// service's response includes the message ID its delivery can be followed by.
+func (app *Config) sendMail(w http.ResponseWriter, r *http.Request, msg MailPayload) {
+	app.forwardToMailService(w, r, http.MethodPost, "/send", msg)
+}
+
+// queueMail queues the given mail as a job for the mail service to send, rather
+// than waiting for it to be sent. Like logEventViaRabbit, the job is saved to the
+// outbox before responding. Since the job is published to RabbitMQ, it can only
+// carry up to events.MAX_QUEUED_ATTACHMENTS_SIZE of attachments. The job ID is the
+// ID of its event, and is also the message ID its delivery can be followed by at
+// GET /mail/messages/{id}
+func (app *Config) queueMail(w http.ResponseWriter, r *http.Request, m MailPayload) {
+
+	event, err := events.NewMailEvent("broker-service", events.MailData(m))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, err)
+	_, err = app.Outbox.Add(events.MAIL_ROUTING_KEY, event)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	// Let the relay know there's something to publish
+	app.Relay.Notify()
+
 	var payload JSONResponse
 	payload.Error = false
-	payload.Message = "sent mail to " + msg.To
+	payload.Message = "queued mail to " + strings.Join(events.MailData(m).Recipients(), ", ")
+	payload.Data = map[string]string{"job_id": event.ID, "message_id": event.ID}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
@@ -162,61 +293,89 @@ func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
 // 	app.writeJSON(w, http.StatusAccepted, payload)
 // }
 
-// // logEventViaRabbit sends a request to the log service to log the given entry via RabbitMQ
-// func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
+// logEventViaRabbit queues the given log entry to be published to RabbitMQ, routed by
+// its level. The entry is saved to the outbox before responding, and the relay
+// publishes it in the background, so it is accepted even while RabbitMQ is down.
+func (app *Config) logEventViaRabbit(w http.ResponseWriter, r *http.Request, l LogPayload) {
 
-// 	// Push the log entry to the RabbitMQ queue
-// 	err := app.pushToQueue(l.Name, l.Data)
-// 	if err != nil {
-// 		app.errorJSON(w, err)
-// 		return
-// 	}
+	// Route by the canonical level, since the listener only binds those
+	level, err := events.NormalizeLevel(l.Level)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	l.Level = level
 
-// 	// Create a JSON response
-// 	var payload JSONResponse
-// 	payload.Error = false
-// 	payload.Message = "logged via RabbitMQ"
+	// Wrap the entry in an event, tied to its trace if it has one
+	event, err := events.NewLogEvent("broker-service", events.LogData(l), events.WithCorrelationID(l.TraceID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
-// 	// Write the response
-// 	app.writeJSON(w, http.StatusAccepted, payload)
-// }
+	// Save the event to the outbox
+	e, err := app.Outbox.Add(events.LogRoutingKey(l.Level), event)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Let the relay know there's something to publish
+	app.Relay.Notify()
+
+	// Create a JSON response
+	var payload JSONResponse
+	payload.Error = false
+	payload.Message = "queued for logging via RabbitMQ"
+	payload.Data = map[string]string{"event_id": event.ID, "outbox_id": e.ID}
 
+	// Write the response
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RPCPayload mirrors the logger service's RPCPayload. It must have the same
+// fields as LogPayload, so one can be converted to the other
 type RPCPayload struct {
-	Name string
-	Data string
+	Name       string
+	Data       string
+	Level      string
+	Source     string
+	TraceID    string
+	Attributes map[string]any
+}
+
+func init() {
+	// Attributes can hold nested objects and arrays, which gob needs to know about
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
 }
 
 // logItemViaGRPC sends the given log entry to the logger service as a gRPC request
 func (app *Config) logItemViaGRPC(w http.ResponseWriter, r *http.Request) {
 
 	// Read the JSON
-	var requestPayload RequestPayload
+	var requestPayload struct {
+		Log LogPayload `json:"log"`
+	}
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// Connect to the gRPC server
-	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	// Convert the entry into its protobuf form
+	entry, err := logToProto(requestPayload.Log)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
-	defer conn.Close()
 
-	// Create the gRPC client
-	c := logs.NewLogServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// Use the shared gRPC client, waiting for it to (re)connect if it has to
+	ctx, cancel := context.WithTimeout(r.Context(), GRPC_TIMEOUT)
 	defer cancel()
 
 	// Try to write a log
-	_, err = c.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: requestPayload.Log.Name,
-			Data: requestPayload.Log.Data,
-		},
-	})
+	_, err = app.Logs.WriteLog(ctx, &logs.LogRequest{LogEntry: entry}, grpc.WaitForReady(true))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -231,6 +390,109 @@ func (app *Config) logItemViaGRPC(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
+// LogBatchPayload is the payload of the "log.batch" action
+type LogBatchPayload struct {
+	Entries []LogPayload `json:"entries"`
+}
+
+// validateLogBatchPayload makes sure there is something to log, and that every entry has a name
+func validateLogBatchPayload(b LogBatchPayload) error {
+	if len(b.Entries) == 0 {
+		return errors.New("at least one log entry is required")
+	}
+
+	for i, l := range b.Entries {
+		err := validateLogPayload(l)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// logBatchViaGRPC sends several log entries to the logger service over gRPC.
+//
+// Batches of up to STREAM_THRESHOLD entries are written with a single WriteLogs call.
+// Anything bigger is streamed with StreamLogs, so the whole batch doesn't have to fit
+// in a single gRPC message.
+func (app *Config) logBatchViaGRPC(w http.ResponseWriter, r *http.Request, b LogBatchPayload) {
+
+	// Convert the entries into their protobuf form
+	entries := make([]*logs.Log, 0, len(b.Entries))
+	for _, l := range b.Entries {
+		entry, err := logToProto(l)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	// Use the shared gRPC client, waiting for it to (re)connect if it has to
+	ctx, cancel := context.WithTimeout(r.Context(), GRPC_TIMEOUT)
+	defer cancel()
+
+	var res *logs.LogsResponse
+	var err error
+
+	if len(entries) <= STREAM_THRESHOLD {
+		res, err = app.Logs.WriteLogs(ctx, &logs.LogsRequest{LogEntries: entries}, grpc.WaitForReady(true))
+	} else {
+		res, err = app.streamLogs(ctx, entries)
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Create a JSON response
+	var payload JSONResponse
+	payload.Error = false
+	payload.Message = fmt.Sprintf("logged %d entries", res.GetCount())
+
+	// Write the response
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// streamLogs sends the given log entries to the logger service one at a time over
+// a single client stream, and returns the logger service's summary once it is done
+func (app *Config) streamLogs(ctx context.Context, entries []*logs.Log) (*logs.LogsResponse, error) {
+
+	stream, err := app.Logs.StreamLogs(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		err = stream.Send(&logs.LogRequest{LogEntry: entry})
+		if err != nil {
+			// The real reason the stream broke comes back from CloseAndRecv
+			break
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// logToProto converts a log entry into its protobuf form
+func logToProto(l LogPayload) (*logs.Log, error) {
+
+	attributes, err := structpb.NewStruct(l.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logs.Log{
+		Name:       l.Name,
+		Data:       l.Data,
+		Level:      l.Level,
+		Source:     l.Source,
+		TraceId:    l.TraceID,
+		Attributes: attributes,
+	}, nil
+}
+
 // logItemViaRPC sends the given log entry to the logger service as an RPC request
 func (app *Config) logItemViaRPC(w http.ResponseWriter, l LogPayload) {
 
@@ -261,30 +523,23 @@ func (app *Config) logItemViaRPC(w http.ResponseWriter, l LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// // pushToQueue initializes an event emitter and sends a log message to a RabbitMQ queue
-// func (app *Config) pushToQueue(name, msg string) error {
-
-// 	// Try to create a new emitter
-// 	emitter, err := event.NewEventEmitter(app.Rabbit)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// Create a LogPayload and turn it into JSON
-// 	payload := LogPayload{Name: name, Data: msg}
-// 	j, _ := json.MarshalIndent(&payload, "", "\t")
-
-// 	// Push the JSON string to the queue
-// 	err = emitter.Push(string(j), "log.INFO")
-// 	if err != nil {
-// 		return err
-// 	}
+// authenticate sends a request to the auth service to verify the user's credentials.
+//
+// If the request carries an "Authorization: Bearer" header, the token is verified
+// instead, and no credentials are needed.
+func (app *Config) authenticate(w http.ResponseWriter, r *http.Request, a AuthPayload) {
 
-// 	return nil
-// }
+	// A bearer token stands in for credentials
+	if token := httputil.BearerToken(r); token != "" {
+		app.verifyToken(w, r, token)
+		return
+	}
 
-// authenticate sends a request to the auth service to verify the user's credentials.
-func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
+	err := validateAuthPayload(a)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
 	// Read the JSON
 	jsonData, _ := json.MarshalIndent(a, "", "\t")
@@ -328,6 +583,9 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 		return
 	}
 
+	// Record the login, for the audit log
+	app.publishLogin(a.Email, jsonFromService.Data)
+
 	// We have valid login, so write a proper response
 	var payload JSONResponse
 	payload.Error = false
@@ -335,3 +593,52 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 	payload.Data = jsonFromService.Data
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
+
+// publishLogin saves an auth event for a successful login to the outbox, for the
+// listener to record in the audit log. The user's ID is taken from the auth service's
+// response. The user is already logged in, so failing to save it is only logged
+func (app *Config) publishLogin(email string, response any) {
+
+	var data struct {
+		User struct {
+			ID int `json:"id"`
+		} `json:"user"`
+	}
+
+	if b, err := json.Marshal(response); err == nil {
+		_ = json.Unmarshal(b, &data)
+	}
+
+	event, err := events.NewAuthEvent("broker-service", events.AuthData{Action: "login", UserID: data.User.ID, Email: email})
+	if err != nil {
+		log.Println("Error creating login event:", err)
+		return
+	}
+
+	_, err = app.Outbox.Add(events.AuthRoutingKey("login"), event)
+	if err != nil {
+		log.Println("Error saving login event:", err)
+		return
+	}
+
+	// Let the relay know there's something to publish
+	app.Relay.Notify()
+}
+
+// verifyToken sends the given access token to the auth service to be verified,
+// and sends back the claims it carries if it is valid.
+func (app *Config) verifyToken(w http.ResponseWriter, r *http.Request, token string) {
+
+	claims, err := httputil.ValidateToken(r.Context(), httputil.AUTH_VALIDATE_URL, token)
+	if err != nil {
+		app.errorJSON(w, err, httputil.AuthStatus(err))
+		return
+	}
+
+	// We have a valid token, so write a proper response
+	var payload JSONResponse
+	payload.Error = false
+	payload.Message = "Successfully authenticated"
+	payload.Data = claims.Raw
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -5,8 +5,13 @@ import (
 	"errors"
 	"io"
 	"net/http"
+
+	"github.com/BlackSound1/go-microservices/events"
 )
 
+// MAX_REQUEST_SIZE is the biggest request body readJSON accepts by default, 1 MB
+const MAX_REQUEST_SIZE = 1 << 20
+
 type JSONResponse struct {
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
@@ -15,11 +20,16 @@ type JSONResponse struct {
 
 // readJSON reads a JSON from a request body into the target data.
 //
-// It checks that the body is not more than 1 MB and that it only contains 1 JSON value.
-func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
-
-	// Make sure JSON file is less than 1 MB. Shouldn't be more than that
-	maxBytes := int64(1048576) // 1 MB
+// It checks that the body is not more than 1 MB, or the given size if there is one,
+// and that it only contains 1 JSON value.
+func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any, size ...int64) error {
+
+	// Make sure JSON file is less than 1 MB, unless the caller expects more, like a
+	// mail with attachments
+	maxBytes := int64(MAX_REQUEST_SIZE)
+	if len(size) > 0 {
+		maxBytes = size[0]
+	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 
 	// Create decoder for JSON
@@ -90,6 +100,24 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	payload.Error = true
 	payload.Message = err.Error()
 
+	// Report every invalid field, like a mail's addresses, one by one
+	var fieldErrors events.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		payload.Data = map[string]any{"errors": fieldErrors}
+	}
+
 	// Send the response
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+	"github.com/go-chi/chi/v5"
+)
+
+const MAIL_SERVICE_URL = "http://mail-service"
+
+// ListMailTemplates lists the templates the mail service can render mail from
+func (app *Config) ListMailTemplates(w http.ResponseWriter, r *http.Request) {
+	app.forwardToMailService(w, r, http.MethodGet, "/templates", nil)
+}
+
+// GetMailMessage reports how the delivery of a mail is going, by the message ID the
+// mail or mail.queue action returned. A queued mail isn't known to the mail service
+// until it takes the job, so it may not be found for a moment
+func (app *Config) GetMailMessage(w http.ResponseWriter, r *http.Request) {
+	app.forwardToMailService(w, r, http.MethodGet, "/messages/"+url.PathEscape(chi.URLParam(r, "id")), nil)
+}
+
+// RenderMail previews a mail as the mail service would send it, without sending it
+func (app *Config) RenderMail(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload MailPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.forwardToMailService(w, r, http.MethodPost, "/render", requestPayload)
+}
+
+// forwardToMailService sends a request to the given mail service endpoint, and
+// relays the mail service's response, status code included.
+func (app *Config) forwardToMailService(w http.ResponseWriter, r *http.Request, method, path string, body any) {
+
+	// Convert the body to JSON, if there is one
+	var requestBody io.Reader
+	if body != nil {
+		jsonData, _ := json.MarshalIndent(body, "", "\t")
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), method, MAIL_SERVICE_URL+path, requestBody)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	// The mail service's admin endpoints need its key, which is only sent to it
+	if app.MailAdminKey != "" {
+		request.Header.Set(httputil.ADMIN_KEY_HEADER, app.MailAdminKey)
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	var jsonFromService JSONResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		app.errorJSON(w, errors.New("error calling mail service"), http.StatusBadGateway)
+		return
+	}
+
+	app.writeJSON(w, response.StatusCode, jsonFromService)
+}
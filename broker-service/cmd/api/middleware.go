@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+)
+
+// requireAdmin only lets requests through if they carry an access token belonging
+// to an active admin. Tokens are checked by the auth service. Browsers can't set
+// headers on an event stream, so the token can also be given as the "access_token"
+// query parameter
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		token := httputil.BearerToken(r)
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		_, err := httputil.RequireAdmin(r.Context(), httputil.AUTH_VALIDATE_URL, token)
+		if err != nil {
+			app.errorJSON(w, err, httputil.AuthStatus(err))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_TailLogs_RequiresToken(t *testing.T) {
+	app := Config{}
+
+	res := httptest.NewRecorder()
+	app.routes().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/logs/stream", nil))
+
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("expected tailing without a token to be refused but got %d", res.Code)
+	}
+}
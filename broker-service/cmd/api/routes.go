@@ -23,10 +23,19 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping")) // Health check
 
+	mux.Get("/health", app.Health)
+
 	// Set up handlers
 	mux.Post("/", app.Broker)
 	mux.Post("/handle", app.HandleSubmission)
+	mux.Get("/actions", app.ListActions)
 	mux.Post("/log-grpc", app.logItemViaGRPC)
+	mux.With(app.requireAdmin).Get("/logs/stream", app.TailLogs) // Every entry, attributes and all, so admins only
+	mux.Get("/mail/templates", app.ListMailTemplates)
+	mux.Post("/mail/render", app.RenderMail)
+	mux.Get("/mail/messages/{id}", app.GetMailMessage)
+	mux.Get("/mail/unsubscribe", app.Unsubscribe)
+	mux.Post("/mail/unsubscribe", app.Unsubscribe)
 
 	return mux
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+)
+
+// MAX_UNSUBSCRIBE_SIZE is the biggest unsubscribe request that is passed on. One-click
+// unsubscribes only send "List-Unsubscribe=One-Click"
+const MAX_UNSUBSCRIBE_SIZE = 4096
+
+// SuppressionPayload is the payload of every "mail.suppression.*" action
+type SuppressionPayload struct {
+	Address string `json:"address,omitempty"`
+	Reason  string `json:"reason,omitempty"` // bounce, complaint, unsubscribe or manual, the default
+}
+
+// suppressionActions returns the "mail.suppression.*" actions, which manage the
+// addresses the mail service won't send to. They all need the caller's admin access
+// token as a bearer token
+func (app *Config) suppressionActions() []Action {
+
+	// suppressionAction builds an action that forwards to the given mail service
+	// endpoint, once the auth service says the caller is an admin
+	suppressionAction := func(name string, validate func(SuppressionPayload) error, forward func(w http.ResponseWriter, r *http.Request, s SuppressionPayload)) Action {
+		return NewAction(name, "suppression", validate, func(w http.ResponseWriter, r *http.Request, s SuppressionPayload) {
+			_, err := httputil.RequireAdmin(r.Context(), httputil.AUTH_VALIDATE_URL, httputil.BearerToken(r))
+			if err != nil {
+				app.errorJSON(w, err, httputil.AuthStatus(err))
+				return
+			}
+
+			forward(w, r, s)
+		})
+	}
+
+	return []Action{
+		suppressionAction("mail.suppression.list", nil, func(w http.ResponseWriter, r *http.Request, _ SuppressionPayload) {
+			app.forwardToMailService(w, r, http.MethodGet, "/suppressions", nil)
+		}),
+		suppressionAction("mail.suppression.add", validateSuppression, func(w http.ResponseWriter, r *http.Request, s SuppressionPayload) {
+			app.forwardToMailService(w, r, http.MethodPost, "/suppressions", s)
+		}),
+		suppressionAction("mail.suppression.remove", validateSuppression, func(w http.ResponseWriter, r *http.Request, s SuppressionPayload) {
+			app.forwardToMailService(w, r, http.MethodDelete, "/suppressions/"+url.PathEscape(s.Address), nil)
+		}),
+	}
+}
+
+// validateSuppression makes sure the payload says which address to act on. The mail
+// service checks that it is actually an address
+func validateSuppression(s SuppressionPayload) error {
+	if s.Address == "" {
+		return errors.New("address is required")
+	}
+	return nil
+}
+
+// Unsubscribe passes the unsubscribe links in mail on to the mail service, which
+// answers with a page for the recipient rather than JSON. A GET asks them to confirm,
+// and a POST, including a mail client's one-click unsubscribe, unsubscribes them
+func (app *Config) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+
+	body := http.MaxBytesReader(w, r.Body, MAX_UNSUBSCRIBE_SIZE)
+
+	request, err := http.NewRequestWithContext(r.Context(), r.Method, MAIL_SERVICE_URL+"/unsubscribe?"+r.URL.RawQuery, body)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	request.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	w.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	w.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(w, response.Body)
+}
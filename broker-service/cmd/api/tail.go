@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/broker/logs"
+	"github.com/BlackSound1/go-microservices/httputil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TailEntry is a newly written log entry, as sent to clients tailing the logs
+type TailEntry struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Level      string         `json:"level"`
+	Source     string         `json:"source,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// TailLogs streams new log entries to the client as Server-Sent Events, by tailing
+// the logger service over gRPC. Like the logger service's own stream, it takes
+// optional "name" and "level" query parameters, and sends each entry as a "log" event.
+// Only admins can tail the logs.
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	// The stream ends when the client goes away
+	stream, err := app.Logs.TailLogs(r.Context(), &logs.TailRequest{
+		Name:     r.URL.Query().Get("name"),
+		MinLevel: r.URL.Query().Get("level"),
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	// The logger service sends headers once the tail is open. If there are none, the
+	// tail was rejected, and receiving returns the reason
+	md, err := stream.Header()
+	if err == nil && md == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			app.errorJSON(w, errors.New(status.Convert(err).Message()))
+			return
+		}
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	// Receive on a separate goroutine, so heartbeats can be sent while waiting
+	entries := make(chan *logs.TailResponse)
+	errs := make(chan error, 1)
+
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case entries <- res:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comment lines keep proxies from timing out a quiet stream
+	heartbeat := time.NewTicker(httputil.SSE_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case err = <-errs:
+			// The logger service went away, so tell the client before giving up
+			_ = httputil.WriteEvent(w, "error", "", map[string]string{"message": err.Error()})
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			err = httputil.WriteHeartbeat(w)
+		case res := <-entries:
+			err = httputil.WriteEvent(w, "log", res.GetId(), tailEntryFromProto(res))
+		}
+		if err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// tailEntryFromProto converts a tailed log entry from its protobuf form
+func tailEntryFromProto(res *logs.TailResponse) TailEntry {
+	entry := res.GetLogEntry()
+
+	return TailEntry{
+		ID:         res.GetId(),
+		Name:       entry.GetName(),
+		Data:       entry.GetData(),
+		Level:      entry.GetLevel(),
+		Source:     entry.GetSource(),
+		TraceID:    entry.GetTraceId(),
+		Attributes: entry.GetAttributes().AsMap(),
+		CreatedAt:  res.GetCreatedAt().AsTime(),
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+)
+
+// UserPayload is the payload of every "user.*" action. Which fields are needed
+// depends on the action, and fields left out of an update are left unchanged
+type UserPayload struct {
+	ID        int     `json:"id,omitempty"`
+	Email     *string `json:"email,omitempty"`
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+	Password  *string `json:"password,omitempty"`
+	Active    *int    `json:"active,omitempty"`
+	Admin     *int    `json:"admin,omitempty"`
+}
+
+// userActions returns the "user.*" actions, which manage users through the auth
+// service. They all need the caller's admin access token as a bearer token
+func (app *Config) userActions() []Action {
+
+	// userAction builds an action that forwards to the given auth service endpoint.
+	// A "%d" in the path is replaced with the payload's user ID
+	userAction := func(name, method, path string, validate func(UserPayload) error, body func(UserPayload) any) Action {
+		return NewAction(name, "user", validate, func(w http.ResponseWriter, r *http.Request, u UserPayload) {
+			url := path
+			if strings.Contains(path, "%d") {
+				url = fmt.Sprintf(path, u.ID)
+			}
+
+			var requestBody any
+			if body != nil {
+				requestBody = body(u)
+			}
+
+			app.forwardToAuthService(w, r, method, url, requestBody)
+		})
+	}
+
+	// The whole payload is sent as the body, minus the ID, which is in the URL
+	withoutID := func(u UserPayload) any {
+		u.ID = 0
+		return u
+	}
+
+	return []Action{
+		userAction("user.list", "GET", "/users/", nil, nil),
+		userAction("user.create", "POST", "/users/", validateNewUser, withoutID),
+		userAction("user.get", "GET", "/users/%d", validateUserID, nil),
+		userAction("user.update", "PUT", "/users/%d", validateUserID, withoutID),
+		userAction("user.deactivate", "POST", "/users/%d/deactivate", validateUserID, nil),
+		userAction("user.delete", "DELETE", "/users/%d", validateUserID, nil),
+		userAction("user.reset-password", "POST", "/users/%d/reset-password", validatePasswordReset, func(u UserPayload) any {
+			return map[string]string{"password": *u.Password}
+		}),
+	}
+}
+
+// validateUserID makes sure the payload says which user to act on
+func validateUserID(u UserPayload) error {
+	if u.ID < 1 {
+		return errors.New("user id is required")
+	}
+	return nil
+}
+
+// validateNewUser makes sure a new user has an email and a password. The auth
+// service checks that they are actually usable
+func validateNewUser(u UserPayload) error {
+	if u.Email == nil || *u.Email == "" || u.Password == nil || *u.Password == "" {
+		return errors.New("email and password are required")
+	}
+	return nil
+}
+
+// validatePasswordReset makes sure the payload has a user ID and a new password
+func validatePasswordReset(u UserPayload) error {
+	err := validateUserID(u)
+	if err != nil {
+		return err
+	}
+
+	if u.Password == nil || *u.Password == "" {
+		return errors.New("password is required")
+	}
+	return nil
+}
+
+// forwardToAuthService sends a request to the given auth service endpoint on behalf
+// of the caller, passing their bearer token along, and relays the auth service's
+// response, status code included.
+func (app *Config) forwardToAuthService(w http.ResponseWriter, r *http.Request, method, path string, body any) {
+
+	token := httputil.BearerToken(r)
+	if token == "" {
+		app.errorJSON(w, errors.New("authorization required"), http.StatusUnauthorized)
+		return
+	}
+
+	// Convert the body to JSON, if there is one
+	var requestBody io.Reader
+	if body != nil {
+		jsonData, _ := json.MarshalIndent(body, "", "\t")
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	// Create a new custom request to the auth service
+	request, err := http.NewRequest(method, "http://auth-service"+path, requestBody)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	// Actually perform the request by creating a client to do so
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Read response body
+	var jsonFromService JSONResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		app.errorJSON(w, errors.New("error calling auth service"))
+		return
+	}
+
+	app.writeJSON(w, response.StatusCode, jsonFromService)
+}
@@ -16,3 +16,15 @@ require (
 	golang.org/x/text v0.19.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
 )
+
+require (
+	github.com/BlackSound1/go-microservices/events v0.0.0
+	github.com/BlackSound1/go-microservices/fileutil v0.0.0
+	github.com/BlackSound1/go-microservices/httputil v0.0.0
+)
+
+replace github.com/BlackSound1/go-microservices/events => ../events
+
+replace github.com/BlackSound1/go-microservices/fileutil => ../fileutil
+
+replace github.com/BlackSound1/go-microservices/httputil => ../httputil
@@ -0,0 +1,302 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/fileutil"
+)
+
+// FAILED_DIR is the subdirectory events are moved to once they run out of attempts
+const FAILED_DIR = "failed"
+
+// ErrNotFound is returned when an event isn't in the outbox
+var ErrNotFound = errors.New("event not found in outbox")
+
+// Event is an event waiting in the outbox to be published
+type Event struct {
+	ID          string          `json:"id"`
+	RoutingKey  string          `json:"routing_key"`
+	Envelope    events.Envelope `json:"envelope"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Store is an outbox kept on the local filesystem, with one file per event.
+//
+// Events are written atomically, so an event is either in the outbox in full or not
+// at all, even if the broker crashes.
+// File names start with the time the event was added, so listing them gives them in
+// the order they were added.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Open opens the outbox in the given directory, creating it if it doesn't exist
+func Open(dir string) (*Store, error) {
+
+	err := os.MkdirAll(filepath.Join(dir, FAILED_DIR), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Add records a new event to be published with the given routing key, and returns it
+func (s *Store) Add(routingKey string, envelope events.Envelope) (Event, error) {
+
+	err := envelope.Validate()
+	if err != nil {
+		return Event{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Event{}, err
+	}
+
+	now := time.Now().UTC()
+	e := Event{
+		ID:          fmt.Sprintf("%020d-%s", now.UnixNano(), id),
+		RoutingKey:  routingKey,
+		Envelope:    envelope,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.write(e)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return e, nil
+}
+
+// Due returns up to limit events that are ready to be attempted, oldest first. Files
+// that can't be read as events are moved to the failed directory, like events that
+// run out of attempts, so the rest of the outbox can still be published
+func (s *Store) Due(now time.Time, limit int) ([]Event, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []Event
+
+	for _, name := range names {
+		e, err := s.read(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			// One bad file mustn't stop every other event being published
+			log.Printf("Moving unreadable event %s out of outbox: %v", name, err)
+
+			err = os.Rename(s.path(name), filepath.Join(s.dir, FAILED_DIR, name+".json"))
+			if err != nil {
+				log.Printf("Error moving event %s out of outbox: %v", name, err)
+			}
+			continue
+		}
+
+		if e.NextAttempt.After(now) {
+			continue
+		}
+
+		due = append(due, e)
+		if len(due) == limit {
+			break
+		}
+	}
+
+	return due, nil
+}
+
+// Len returns how many events are waiting in the outbox
+func (s *Store) Len() (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names), nil
+}
+
+// Delete removes a published event from the outbox
+func (s *Store) Delete(id string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Retry records a failed attempt to publish an event, and when to try it next
+func (s *Store) Retry(e Event, cause error, next time.Time) error {
+
+	e.Attempts++
+	e.NextAttempt = next
+	e.LastError = cause.Error()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path(e.ID)); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return s.write(e)
+}
+
+// Fail moves an event that will never be published out of the outbox, into the
+// failed directory, where it is kept for someone to look at
+func (s *Store) Fail(e Event, cause error) error {
+
+	e.Attempts++
+	e.LastError = cause.Error()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.write(e)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(s.path(e.ID), filepath.Join(s.dir, FAILED_DIR, e.ID+".json"))
+}
+
+// list returns the names of the events in the outbox, oldest first
+func (s *Store) list() ([]string, error) {
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".json"))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// read reads an event from its file
+func (s *Store) read(id string) (Event, error) {
+
+	b, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return Event{}, err
+	}
+
+	var e Event
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return Event{}, fmt.Errorf("reading outbox event %s: %w", id, err)
+	}
+
+	err = upgradeEnvelope(b, &e.Envelope)
+	if err != nil {
+		return Event{}, fmt.Errorf("reading outbox event %s: %w", id, err)
+	}
+
+	return e, nil
+}
+
+// upgradeEnvelope fills in the parts of an envelope that events written before
+// envelopes were CloudEvents kept under other names, or didn't have at all, so
+// they can still be published
+func upgradeEnvelope(b []byte, envelope *events.Envelope) error {
+
+	var old struct {
+		Envelope struct {
+			CorrelationID string `json:"correlation_id"`
+			SchemaVersion int    `json:"schema_version"`
+		} `json:"envelope"`
+	}
+
+	err := json.Unmarshal(b, &old)
+	if err != nil {
+		return err
+	}
+
+	if envelope.SpecVersion == "" {
+		envelope.SpecVersion = events.SPEC_VERSION
+	}
+
+	if envelope.CorrelationID == "" {
+		envelope.CorrelationID = old.Envelope.CorrelationID
+	}
+
+	if envelope.SchemaVersion == 0 {
+		envelope.SchemaVersion = old.Envelope.SchemaVersion
+	}
+
+	return nil
+}
+
+// write saves an event to its file, replacing it if it already exists
+func (s *Store) write(e Event) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(s.path(e.ID), b)
+}
+
+// path returns the file an event is kept in
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// newID generates a random ID for an event
+func newID() (string, error) {
+
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
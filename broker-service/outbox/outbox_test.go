@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+// fakePublisher records what it publishes, and fails with err if it is set
+type fakePublisher struct {
+	err       error
+	published []string
+}
+
+func (p *fakePublisher) Push(ctx context.Context, e events.Envelope, routingKey string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.published = append(p.published, routingKey+" "+string(e.Data))
+	return nil
+}
+
+// logEvent creates a log event with the given name
+func logEvent(t *testing.T, name string) events.Envelope {
+	e, err := events.NewLogEvent("broker-service", events.LogData{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func Test_Store(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Add("log.INFO", logEvent(t, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Add("log.ERROR", logEvent(t, "second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := store.Due(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != 2 || due[0].ID != first.ID {
+		t.Fatalf("expected both events, oldest first, but got %+v", due)
+	}
+
+	// Events that are waiting to be retried aren't due yet
+	err = store.Retry(first, errors.New("boom"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, _ = store.Due(time.Now(), 10)
+	if len(due) != 1 || due[0].RoutingKey != "log.ERROR" {
+		t.Fatalf("expected only the second event to be due but got %+v", due)
+	}
+
+	// Failed events leave the outbox
+	err = store.Fail(due[0], errors.New("unroutable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, _ := store.Len()
+	if n != 1 {
+		t.Errorf("expected 1 event left in the outbox but got %d", n)
+	}
+
+	if err := store.Delete(due[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a failed event but got %v", err)
+	}
+
+	// A corrupt file is moved aside rather than stopping the rest being published
+	err = os.WriteFile(store.path("00000000000000000000-corrupt"), []byte("{oops"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = store.Due(time.Now().Add(2*time.Hour), 10)
+	if err != nil || len(due) != 1 || due[0].ID != first.ID {
+		t.Fatalf("expected the first event to be due but got %+v (%v)", due, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.dir, FAILED_DIR, "00000000000000000000-corrupt.json")); err != nil {
+		t.Errorf("expected the corrupt file to be moved to %s: %v", FAILED_DIR, err)
+	}
+}
+
+func Test_Store_OldEnvelopes(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events written before envelopes were CloudEvents can still be published
+	old := `{"id":"00000000000000000000-old","routing_key":"log.INFO","envelope":{"id":"1","type":"log","source":"broker-service","time":"2026-01-01T00:00:00Z","correlation_id":"request-1","schema_version":1,"data":{"name":"old","data":""}}}`
+
+	err = os.WriteFile(store.path("00000000000000000000-old"), []byte(old), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := store.Due(time.Now(), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("expected the old event to be due but got %+v (%v)", due, err)
+	}
+
+	envelope := due[0].Envelope
+	if envelope.SpecVersion != events.SPEC_VERSION || envelope.CorrelationID != "request-1" || envelope.SchemaVersion != 1 {
+		t.Errorf("expected the old envelope to be upgraded but got %+v", envelope)
+	}
+
+	if err := envelope.Validate(); err != nil {
+		t.Errorf("expected the upgraded envelope to be valid but got %v", err)
+	}
+}
+
+func Test_Relay(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = store.Add("log.INFO", logEvent(t, "event"))
+
+	// Nothing is lost, or counted as an attempt, while RabbitMQ is down
+	pub := &fakePublisher{err: events.ErrNotConnected}
+	relay := NewRelay(store, pub)
+
+	relay.drain(context.Background())
+
+	due, _ := store.Due(time.Now(), 10)
+	if len(due) != 1 || due[0].Attempts != 0 {
+		t.Fatalf("expected the event to still be due, with no attempts, but got %+v", due)
+	}
+
+	// Other failures are retried later
+	pub.err = events.ErrUnroutable
+	relay.drain(context.Background())
+
+	due, _ = store.Due(time.Now().Add(RETRY_MIN_BACKOFF+time.Second), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError == "" {
+		t.Fatalf("expected one recorded attempt but got %+v", due)
+	}
+
+	// Once RabbitMQ takes it, it leaves the outbox
+	pub.err = nil
+	_ = relay.publish(context.Background(), due[0])
+
+	if len(pub.published) != 1 || pub.published[0] != `log.INFO {"name":"event","data":""}` {
+		t.Errorf("unexpected published events %v", pub.published)
+	}
+
+	if n, _ := store.Len(); n != 0 {
+		t.Errorf("expected an empty outbox but it has %d events", n)
+	}
+}
@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+const (
+	RELAY_INTERVAL    = 5 * time.Second  // How often the outbox is checked if nothing wakes the relay
+	RELAY_BATCH_SIZE  = 100              // How many events are published in one go
+	RETRY_MIN_BACKOFF = 1 * time.Second  // How long to wait before retrying an event the first time
+	RETRY_MAX_BACKOFF = 5 * time.Minute  // The longest to ever wait before retrying an event
+	MAX_ATTEMPTS      = 50               // How many times to try an event before giving up on it
+	PUSH_TIMEOUT      = 10 * time.Second // How long to wait for a single event to be confirmed
+)
+
+// Publisher publishes an event with the given routing key. events.Emitter is one
+type Publisher interface {
+	Push(ctx context.Context, event events.Envelope, routingKey string) error
+}
+
+// Relay publishes the events in an outbox, retrying the ones that fail with an
+// increasing delay. Events are removed from the outbox once they are confirmed,
+// so every event is published at least once.
+type Relay struct {
+	store     *Store
+	publisher Publisher
+	wake      chan struct{}
+}
+
+// NewRelay creates a Relay that publishes the events in the given outbox
+func NewRelay(store *Store, publisher Publisher) *Relay {
+	return &Relay{
+		store:     store,
+		publisher: publisher,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay up to publish new events straight away, rather than
+// waiting for its next check
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+		// It has already been woken up
+	}
+}
+
+// Run publishes events until the given context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(RELAY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// drain publishes every event that is due, a batch at a time. It stops early if
+// RabbitMQ can't be reached, since every other event would fail too
+func (r *Relay) drain(ctx context.Context) {
+
+	for ctx.Err() == nil {
+		due, err := r.store.Due(time.Now(), RELAY_BATCH_SIZE)
+		if err != nil {
+			log.Println("Error reading outbox:", err)
+			return
+		}
+
+		for _, e := range due {
+			err = r.publish(ctx, e)
+			if errors.Is(err, events.ErrNotConnected) {
+				return
+			}
+		}
+
+		if len(due) < RELAY_BATCH_SIZE {
+			return
+		}
+	}
+}
+
+// publish publishes a single event, and updates the outbox with how it went
+func (r *Relay) publish(ctx context.Context, e Event) error {
+
+	pushCtx, cancel := context.WithTimeout(ctx, PUSH_TIMEOUT)
+	defer cancel()
+
+	err := r.publisher.Push(pushCtx, e.Envelope, e.RoutingKey)
+	if err == nil {
+		err = r.store.Delete(e.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Error removing published event %s from outbox: %v", e.ID, err)
+		}
+		return nil
+	}
+
+	// Not being connected isn't the event's fault, so it doesn't count as an attempt
+	if errors.Is(err, events.ErrNotConnected) {
+		return err
+	}
+
+	if e.Attempts+1 >= MAX_ATTEMPTS {
+		log.Printf("Giving up on event %s after %d attempts: %v", e.ID, e.Attempts+1, err)
+
+		failErr := r.store.Fail(e, err)
+		if failErr != nil {
+			log.Printf("Error moving event %s out of outbox: %v", e.ID, failErr)
+		}
+		return err
+	}
+
+	wait := retryBackoff(e.Attempts)
+	log.Printf("Error publishing event %s, retrying in %s: %v", e.ID, wait, err)
+
+	retryErr := r.store.Retry(e, err, time.Now().Add(wait))
+	if retryErr != nil && !errors.Is(retryErr, ErrNotFound) {
+		log.Printf("Error updating event %s in outbox: %v", e.ID, retryErr)
+	}
+
+	return err
+}
+
+// retryBackoff returns how long to wait before retrying an event that has already
+// failed the given number of times. It doubles each time, up to RETRY_MAX_BACKOFF
+func retryBackoff(attempts int) time.Duration {
+	if attempts >= 16 {
+		return RETRY_MAX_BACKOFF
+	}
+	return min(RETRY_MIN_BACKOFF<<attempts, RETRY_MAX_BACKOFF)
+}
@@ -0,0 +1,238 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	SPEC_VERSION            = "1.0"                          // The version of the CloudEvents spec envelopes follow
+	CONTENT_TYPE_JSON       = "application/json"             // The content type of every event's data
+	CONTENT_TYPE_STRUCTURED = "application/cloudevents+json" // The content type of a whole envelope, in structured mode
+	HEADER_PREFIX           = "ce-"                          // What the headers carrying attributes start with, in binary mode
+	MAX_EVENT_SIZE          = 1 << 20                        // The biggest event that is read over HTTP, 1 MB
+)
+
+// Mode is how an event is laid out in a message, following the CloudEvents protocol
+// bindings
+type Mode int
+
+const (
+	MODE_STRUCTURED Mode = iota // The whole envelope is the body, as JSON
+	MODE_BINARY                 // The data is the body, and every other attribute is a ce-* header
+)
+
+// ParseMode parses "structured" or "binary" into a Mode. An empty string is structured
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "structured":
+		return MODE_STRUCTURED, nil
+	case "binary":
+		return MODE_BINARY, nil
+	default:
+		return MODE_STRUCTURED, fmt.Errorf("unknown CloudEvents mode %q", s)
+	}
+}
+
+// String returns the name of the mode, as ParseMode parses it
+func (m Mode) String() string {
+	if m == MODE_BINARY {
+		return "binary"
+	}
+	return "structured"
+}
+
+// encode returns the body of a message carrying the event in the given mode, along
+// with its content type and, in binary mode, the attributes to send as headers
+func (e Envelope) encode(mode Mode) (body []byte, contentType string, headers map[string]string, err error) {
+
+	err = e.Validate()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if mode != MODE_BINARY {
+		body, err = json.Marshal(e)
+		return body, CONTENT_TYPE_STRUCTURED, nil, err
+	}
+
+	contentType = e.DataContentType
+	if contentType == "" {
+		contentType = CONTENT_TYPE_JSON
+	}
+
+	headers = map[string]string{
+		HEADER_PREFIX + "specversion":   e.SpecVersion,
+		HEADER_PREFIX + "id":            e.ID,
+		HEADER_PREFIX + "type":          e.Type,
+		HEADER_PREFIX + "source":        e.Source,
+		HEADER_PREFIX + "schemaversion": strconv.Itoa(e.SchemaVersion),
+	}
+
+	if !e.Time.IsZero() {
+		headers[HEADER_PREFIX+"time"] = e.Time.Format(time.RFC3339Nano)
+	}
+
+	if e.CorrelationID != "" {
+		headers[HEADER_PREFIX+"correlationid"] = e.CorrelationID
+	}
+
+	return e.Data, contentType, headers, nil
+}
+
+// decode reads an event from a message in either mode. Messages with the structured
+// content type, or without a ce-specversion header, are read as structured. header
+// looks up a ce-* header by its name without the prefix
+func decode(contentType string, header func(name string) string, body []byte) (Envelope, error) {
+
+	if isStructured(contentType) || header("specversion") == "" {
+		return Decode(body)
+	}
+
+	e := Envelope{
+		SpecVersion:     header("specversion"),
+		ID:              header("id"),
+		Type:            header("type"),
+		Source:          header("source"),
+		DataContentType: contentType,
+		CorrelationID:   header("correlationid"),
+		Data:            body,
+	}
+
+	if t := header("time"); t != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("%w: time: %w", ErrInvalidEvent, err)
+		}
+		e.Time = parsed
+	}
+
+	if v := header("schemaversion"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("%w %q", ErrUnsupportedVersion, v)
+		}
+		e.SchemaVersion = version
+	}
+
+	return e, e.Validate()
+}
+
+// Publishing returns an AMQP message carrying the event in the given mode. The
+// event's ID, type, source, correlation ID and time are also set as the message's
+// properties in both modes
+func (e Envelope) Publishing(mode Mode) (amqp.Publishing, error) {
+
+	body, contentType, headers, err := e.encode(mode)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	var table amqp.Table
+	if len(headers) > 0 {
+		table = amqp.Table{}
+		for name, value := range headers {
+			table[name] = value
+		}
+	}
+
+	return amqp.Publishing{
+		Headers:       table,
+		ContentType:   contentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     e.ID,
+		Type:          e.Type,
+		AppId:         e.Source,
+		CorrelationId: e.CorrelationID,
+		Timestamp:     e.Time,
+		Body:          body,
+	}, nil
+}
+
+// DecodeDelivery reads an event from an AMQP message in either mode
+func DecodeDelivery(d amqp.Delivery) (Envelope, error) {
+	return decode(d.ContentType, func(name string) string {
+		switch v := d.Headers[HEADER_PREFIX+name].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case []byte:
+			return string(v)
+		default:
+			// Other tools may send numbers as numbers
+			return fmt.Sprint(v)
+		}
+	}, d.Body)
+}
+
+// NewRequest returns an HTTP request carrying the event in the given mode
+func (e Envelope) NewRequest(ctx context.Context, method, url string, mode Mode) (*http.Request, error) {
+
+	body, contentType, headers, err := e.encode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return req, nil
+}
+
+// IsCloudEvent reports whether an HTTP request with the given headers carries a
+// CloudEvent, in either mode
+func IsCloudEvent(header http.Header) bool {
+	return isStructured(header.Get("Content-Type")) || header.Get(HEADER_PREFIX+"specversion") != ""
+}
+
+// DecodeRequest reads an event from an HTTP request in either mode. Bodies bigger
+// than MAX_EVENT_SIZE are refused
+func DecodeRequest(r *http.Request) (Envelope, error) {
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_EVENT_SIZE+1))
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	if len(body) > MAX_EVENT_SIZE {
+		return Envelope{}, fmt.Errorf("%w: bigger than %d bytes", ErrInvalidEvent, MAX_EVENT_SIZE)
+	}
+
+	return decode(r.Header.Get("Content-Type"), func(name string) string {
+		return r.Header.Get(HEADER_PREFIX + name)
+	}, body)
+}
+
+// isStructured reports whether a content type is that of a structured mode event
+func isStructured(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == CONTENT_TYPE_STRUCTURED
+}
+
+// isJSON reports whether a content type is JSON, like application/json or
+// application/problem+json
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == CONTENT_TYPE_JSON || strings.HasSuffix(mediaType, "+json")
+}
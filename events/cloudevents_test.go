@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func Test_Publishing_RoundTrip(t *testing.T) {
+	e, err := NewLogEvent("broker-service", LogData{Name: "event", Data: "some data"}, WithCorrelationID("req-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []Mode{MODE_STRUCTURED, MODE_BINARY} {
+		msg, err := e.Publishing(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mode == MODE_BINARY && (msg.ContentType != CONTENT_TYPE_JSON || msg.Headers["ce-id"] != e.ID || string(msg.Body) != string(e.Data)) {
+			t.Errorf("binary: expected the data as the body and attributes as headers but got %+v", msg)
+		}
+
+		if mode == MODE_STRUCTURED && (msg.ContentType != CONTENT_TYPE_STRUCTURED || len(msg.Headers) != 0) {
+			t.Errorf("structured: expected the whole envelope as the body but got %+v", msg)
+		}
+
+		decoded, err := DecodeDelivery(amqp.Delivery{Headers: msg.Headers, ContentType: msg.ContentType, Body: msg.Body})
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		if decoded.ID != e.ID || decoded.Source != e.Source || decoded.CorrelationID != "req-1" || !decoded.Time.Equal(e.Time) {
+			t.Errorf("%s: expected %+v but got %+v", mode, e, decoded)
+		}
+	}
+}
+
+func Test_NewRequest_RoundTrip(t *testing.T) {
+	e, err := NewMailEvent("broker-service", MailData{To: Addresses{"you@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []Mode{MODE_STRUCTURED, MODE_BINARY} {
+		req, err := e.NewRequest(context.Background(), http.MethodPost, "http://mail-service/send", mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsCloudEvent(req.Header) {
+			t.Errorf("%s: expected a CloudEvent but got headers %v", mode, req.Header)
+		}
+
+		decoded, err := DecodeRequest(req)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		data, err := decoded.MailData()
+		if err != nil || data.To[0] != "you@example.com" {
+			t.Errorf("%s: unexpected data %+v (%v)", mode, data, err)
+		}
+	}
+}
+
+func Test_DecodeDelivery_Binary_Invalid(t *testing.T) {
+	headers := amqp.Table{
+		"ce-specversion":   "1.0",
+		"ce-id":            "1",
+		"ce-type":          "log",
+		"ce-source":        "test",
+		"ce-schemaversion": int32(3),
+	}
+
+	_, err := DecodeDelivery(amqp.Delivery{Headers: headers, ContentType: CONTENT_TYPE_JSON, Body: []byte(`{"name":"event"}`)})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion but got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package events
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	MIN_BACKOFF = 1 * time.Second  // How long to wait before the first reconnection attempt
+	MAX_BACKOFF = 30 * time.Second // The longest to ever wait between reconnection attempts
+)
+
+// The states a Connection can be in
+const (
+	STATE_CONNECTING   = "connecting"   // Connecting for the first time
+	STATE_CONNECTED    = "connected"    // Connected, and set up
+	STATE_RECONNECTING = "reconnecting" // Lost the connection, and trying to get it back
+	STATE_CLOSED       = "closed"       // Closed on purpose, and staying that way
+)
+
+// ErrNotConnected is returned when a channel is asked for while the Connection is down
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// Connection is a connection to RabbitMQ that reconnects by itself whenever it is
+// lost, waiting a little longer after each failed attempt.
+//
+// Anything that needs to be set up on a new connection, like exchanges, queues and
+// consumers, is registered with OnConnect, and is set up again after every reconnect.
+type Connection struct {
+	url       string
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	onConnect []func(*amqp.Connection) error
+	status    Status
+	done      chan struct{}
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// Status describes the state of a Connection, for health checks
+type Status struct {
+	State       string    `json:"state"`
+	Connected   bool      `json:"connected"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Reconnects  int       `json:"reconnects"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// NewConnection creates a Connection to the RabbitMQ server at the given URL. It
+// doesn't connect until Start is called
+func NewConnection(url string) *Connection {
+	return &Connection{
+		url:    url,
+		status: Status{State: STATE_CONNECTING},
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+}
+
+// OnConnect registers a function to run every time the Connection connects, before
+// it counts as connected. If the function fails, the connection is dropped and tried
+// again. Functions should be registered before calling Start
+func (c *Connection) OnConnect(setup func(*amqp.Connection) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onConnect = append(c.onConnect, setup)
+}
+
+// Start connects in the background, and keeps reconnecting until Close is called.
+// It waits up to the given time for the first connection, and returns an error if
+// there isn't one by then. The Connection keeps trying either way
+func (c *Connection) Start(wait time.Duration) error {
+
+	go c.run()
+
+	select {
+	case <-c.ready:
+		return nil
+	case <-time.After(wait):
+		return errors.New("timed out waiting to connect to RabbitMQ")
+	}
+}
+
+// Channel opens a new channel on the current connection
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.conn == nil || c.conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	return c.conn.Channel()
+}
+
+// Status returns the current state of the Connection
+func (c *Connection) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.status
+}
+
+// Close closes the connection, and stops it from reconnecting
+func (c *Connection) Close() error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status.State == STATE_CLOSED {
+		return nil
+	}
+
+	c.status.State = STATE_CLOSED
+	c.status.Connected = false
+	close(c.done)
+
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+
+	return nil
+}
+
+// run connects, waits for the connection to be lost, and connects again, until the
+// Connection is closed
+func (c *Connection) run() {
+
+	attempt := 0
+
+	for {
+		conn, err := c.connect()
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+
+			c.setError(err)
+
+			wait := backoff(attempt)
+			attempt++
+			log.Printf("RabbitMQ not ready (%v), retrying in %s...", err, wait.Round(time.Millisecond))
+
+			select {
+			case <-time.After(wait):
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		attempt = 0
+		log.Println("Connected to RabbitMQ")
+
+		// Wait for the connection to drop
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-c.done:
+			return
+		case amqpErr := <-closed:
+			if c.isClosed() {
+				return
+			}
+
+			log.Println("Lost connection to RabbitMQ:", amqpErr)
+
+			c.mu.Lock()
+			c.conn = nil
+			c.status.State = STATE_RECONNECTING
+			c.status.Connected = false
+			c.status.Reconnects++
+			if amqpErr != nil {
+				c.status.LastError = amqpErr.Error()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// connect dials RabbitMQ and runs the OnConnect functions on the new connection
+func (c *Connection) connect() (*amqp.Connection, error) {
+
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	setups := c.onConnect
+	c.mu.RUnlock()
+
+	for _, setup := range setups {
+		err = setup(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Don't keep a connection made while closing
+	if c.status.State == STATE_CLOSED {
+		_ = conn.Close()
+		return nil, ErrNotConnected
+	}
+
+	c.conn = conn
+	c.status.State = STATE_CONNECTED
+	c.status.Connected = true
+	c.status.ConnectedAt = time.Now()
+
+	c.readyOnce.Do(func() { close(c.ready) })
+
+	return conn, nil
+}
+
+// setError records why the last connection attempt failed
+func (c *Connection) setError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.LastError = err.Error()
+}
+
+// isClosed reports whether Close has been called
+func (c *Connection) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before the given reconnection attempt. The wait
+// doubles with each attempt, up to MAX_BACKOFF, and is randomised so that services
+// that lost the connection together don't all retry at the same moment
+func backoff(attempt int) time.Duration {
+
+	wait := MAX_BACKOFF
+	if attempt < 16 {
+		wait = min(MIN_BACKOFF<<attempt, MAX_BACKOFF)
+	}
+
+	// Wait somewhere between half and all of it
+	return wait/2 + rand.N(wait/2+1)
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	CHANNEL_POOL_SIZE = 4               // How many idle publishing channels to keep open
+	PUBLISH_TIMEOUT   = 5 * time.Second // How long to wait for a confirm if the caller doesn't say
+)
+
+// ErrNacked is returned when RabbitMQ refuses to take responsibility for a message
+var ErrNacked = errors.New("message was nacked by RabbitMQ")
+
+// ErrUnroutable is returned when a message doesn't match any queue bound to the exchange
+var ErrUnroutable = errors.New("message could not be routed to a queue")
+
+type Emitter struct {
+	connection *Connection
+	mode       Mode
+	pool       chan *publisher
+}
+
+// publisher is a channel in confirm mode, along with the messages it has had returned
+type publisher struct {
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+// Setup declares the exchange on the given connection. It runs every time the
+// Connection connects
+func (e *Emitter) Setup(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	return DeclareExchange(channel)
+}
+
+// Push publishes the given event as a persistent CloudEvents message to the events
+// exchange, in the emitter's mode, with the given routing key, and waits for RabbitMQ
+// to confirm it has it.
+//
+// It returns ErrNacked if RabbitMQ refuses the message, and ErrUnroutable if no queue
+// is bound to receive it. If ctx has no deadline, it waits up to PUBLISH_TIMEOUT.
+func (e *Emitter) Push(ctx context.Context, event Envelope, routingKey string) error {
+
+	msg, err := event.Publishing(e.mode)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, PUBLISH_TIMEOUT)
+		defer cancel()
+	}
+
+	// Try to get a channel
+	p, err := e.get()
+	if err != nil {
+		return err
+	}
+	defer e.put(p)
+
+	// Try to publish to the channel
+	confirm, err := p.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		LOGS_EXCHANGE,
+		routingKey,
+		true,  // Mandatory, so unroutable messages come back instead of vanishing
+		false, // Immediate
+		msg,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Wait for RabbitMQ to confirm it
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !acked {
+		return ErrNacked
+	}
+
+	// RabbitMQ returns unroutable messages before acking them, so if it was returned
+	// it's already waiting
+	select {
+	case ret := <-p.returns:
+		return fmt.Errorf("%w: %s (%d)", ErrUnroutable, ret.ReplyText, ret.ReplyCode)
+	default:
+		return nil
+	}
+}
+
+// get takes an idle channel from the pool, or opens a new one if there aren't any
+func (e *Emitter) get() (*publisher, error) {
+
+	for {
+		select {
+		case p := <-e.pool:
+			// Channels from a lost connection are useless
+			if p.channel.IsClosed() {
+				continue
+			}
+
+			// Forget anything returned after its last message was dealt with
+			for len(p.returns) > 0 {
+				<-p.returns
+			}
+
+			return p, nil
+		default:
+			return e.open()
+		}
+	}
+}
+
+// put gives a channel back to the pool, or closes it if the pool is full
+func (e *Emitter) put(p *publisher) {
+
+	if p.channel.IsClosed() {
+		return
+	}
+
+	select {
+	case e.pool <- p:
+	default:
+		_ = p.channel.Close()
+	}
+}
+
+// open opens a new channel in confirm mode
+func (e *Emitter) open() (*publisher, error) {
+
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.Confirm(false)
+	if err != nil {
+		_ = channel.Close()
+		return nil, err
+	}
+
+	p := publisher{
+		channel: channel,
+		returns: channel.NotifyReturn(make(chan amqp.Return, 1)),
+	}
+
+	return &p, nil
+}
+
+// NewEventEmitter returns an Emitter that can be used to send messages to RabbitMQ,
+// laid out in the given CloudEvents mode. It should be created before the Connection
+// is started, so the exchange it publishes to is declared on every connection
+func NewEventEmitter(conn *Connection, mode Mode) (Emitter, error) {
+
+	if conn == nil {
+		return Emitter{}, errors.New("a connection is required")
+	}
+
+	emitter := Emitter{
+		connection: conn,
+		mode:       mode,
+		pool:       make(chan *publisher, CHANNEL_POOL_SIZE),
+	}
+
+	// Set up the emitter whenever the connection is (re)made
+	conn.OnConnect(emitter.Setup)
+
+	return emitter, nil
+}
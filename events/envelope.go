@@ -0,0 +1,317 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The types of event that can be published
+const (
+	TYPE_LOG  = "log"  // A log entry to be written by the logger service
+	TYPE_AUTH = "auth" // Something that happened to a user, like logging in
+	TYPE_MAIL = "mail" // An email to be sent by the mail service
+)
+
+// schemaVersions is the newest version of each event type's data that this package
+// understands. Every version up to it can be decoded
+var schemaVersions = map[string]int{
+	TYPE_LOG:  1,
+	TYPE_AUTH: 1,
+	TYPE_MAIL: 1,
+}
+
+// ErrInvalidEvent is wrapped by every error from decoding an event that isn't
+// a well-formed envelope
+var ErrInvalidEvent = errors.New("invalid event")
+
+// ErrUnknownType is returned when decoding an event of a type this package doesn't know
+var ErrUnknownType = fmt.Errorf("%w: unknown type", ErrInvalidEvent)
+
+// ErrUnsupportedVersion is returned when decoding an event whose schema version is
+// newer than this package understands, or isn't a version at all
+var ErrUnsupportedVersion = fmt.Errorf("%w: unsupported schema version", ErrInvalidEvent)
+
+// Envelope wraps every event published between services. It is a CloudEvents 1.0
+// event, and marshals to JSON in the CloudEvents structured format. The correlation
+// ID and schema version are CloudEvents extension attributes. The data is kept as raw
+// JSON until it is decoded with the method for its type, like LogData
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	SchemaVersion   int             `json:"schemaversion"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// LogData is the data of a log event
+type LogData struct {
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Level      string         `json:"level,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// AuthData is the data of an auth event
+type AuthData struct {
+	Action string `json:"action"` // What happened, like "login" or "logout"
+	UserID int    `json:"user_id,omitempty"`
+	Email  string `json:"email"`
+}
+
+// MailData is the data of a mail event. The mail is rendered from the named template,
+// or the mail service's default one, with the given data
+type MailData struct {
+	From            string            `json:"from"`
+	To              Addresses         `json:"to"`
+	CC              Addresses         `json:"cc,omitempty"`
+	BCC             Addresses         `json:"bcc,omitempty"`
+	ReplyTo         string            `json:"reply_to,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Subject         string            `json:"subject"`
+	Message         string            `json:"message"`
+	Template        string            `json:"template,omitempty"`
+	TemplateVersion int               `json:"template_version,omitempty"`
+	Data            map[string]any    `json:"data,omitempty"`
+	Attachments     []Attachment      `json:"attachments,omitempty"`
+}
+
+// Option sets an optional field of a new event
+type Option func(*Envelope)
+
+// WithCorrelationID ties a new event to whatever caused it, like an incoming
+// request or another event
+func WithCorrelationID(id string) Option {
+	return func(e *Envelope) {
+		e.CorrelationID = id
+	}
+}
+
+// NewLogEvent creates a log event from the given source service
+func NewLogEvent(source string, data LogData, opts ...Option) (Envelope, error) {
+	if data.Name == "" {
+		return Envelope{}, errors.New("log events need a name")
+	}
+
+	return newEnvelope(TYPE_LOG, source, data, opts)
+}
+
+// NewAuthEvent creates an auth event from the given source service
+func NewAuthEvent(source string, data AuthData, opts ...Option) (Envelope, error) {
+	if data.Action == "" {
+		return Envelope{}, errors.New("auth events need an action")
+	}
+
+	return newEnvelope(TYPE_AUTH, source, data, opts)
+}
+
+// NewMailEvent creates a mail event from the given source service. Its attachments
+// must fit in MAX_QUEUED_ATTACHMENTS_SIZE, so it can be published
+func NewMailEvent(source string, data MailData, opts ...Option) (Envelope, error) {
+	err := data.Validate()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	total := 0
+	for _, a := range data.Attachments {
+		total += len(a.Content)
+	}
+
+	if total > MAX_QUEUED_ATTACHMENTS_SIZE {
+		return Envelope{}, FieldErrors{{Field: "attachments", Error: fmt.Sprintf("bigger than %d bytes together, the most a queued mail can carry", MAX_QUEUED_ATTACHMENTS_SIZE)}}
+	}
+
+	return newEnvelope(TYPE_MAIL, source, data, opts)
+}
+
+// newEnvelope wraps the given data in an envelope of the given type, at the newest
+// schema version
+func newEnvelope(eventType, source string, data any, opts []Option) (Envelope, error) {
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	e := Envelope{
+		SpecVersion:     SPEC_VERSION,
+		ID:              id,
+		Type:            eventType,
+		Source:          source,
+		Time:            time.Now().UTC(),
+		DataContentType: CONTENT_TYPE_JSON,
+		SchemaVersion:   schemaVersions[eventType],
+		Data:            body,
+	}
+
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	return e, nil
+}
+
+// Decode reads an envelope from JSON in the CloudEvents structured format, making sure it is well-formed, and that its
+// type and schema version are ones this package understands
+func Decode(body []byte) (Envelope, error) {
+
+	var e Envelope
+
+	err := json.Unmarshal(body, &e)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+
+	return e, e.Validate()
+}
+
+// Validate makes sure the envelope is well-formed, and that its type and schema
+// version are ones this package understands
+func (e Envelope) Validate() error {
+
+	if e.ID == "" || e.Type == "" || e.Source == "" || len(e.Data) == 0 {
+		return fmt.Errorf("%w: id, type, source and data are required", ErrInvalidEvent)
+	}
+
+	if e.SpecVersion != SPEC_VERSION {
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalidEvent, e.SpecVersion)
+	}
+
+	// Every type of event has JSON data
+	if e.DataContentType != "" && !isJSON(e.DataContentType) {
+		return fmt.Errorf("%w: unsupported datacontenttype %q", ErrInvalidEvent, e.DataContentType)
+	}
+
+	if !json.Valid(e.Data) {
+		return fmt.Errorf("%w: data is not valid JSON", ErrInvalidEvent)
+	}
+
+	newest, ok := schemaVersions[e.Type]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownType, e.Type)
+	}
+
+	if e.SchemaVersion < 1 || e.SchemaVersion > newest {
+		return fmt.Errorf("%w %d for %s events", ErrUnsupportedVersion, e.SchemaVersion, e.Type)
+	}
+
+	return nil
+}
+
+// LogData decodes the data of a log event
+func (e Envelope) LogData() (LogData, error) {
+	var data LogData
+	return data, e.decodeData(TYPE_LOG, &data)
+}
+
+// AuthData decodes the data of an auth event
+func (e Envelope) AuthData() (AuthData, error) {
+	var data AuthData
+	return data, e.decodeData(TYPE_AUTH, &data)
+}
+
+// MailData decodes the data of a mail event
+func (e Envelope) MailData() (MailData, error) {
+	var data MailData
+	return data, e.decodeData(TYPE_MAIL, &data)
+}
+
+// decodeData decodes the envelope's data into target, making sure it is the
+// expected type of event
+func (e Envelope) decodeData(eventType string, target any) error {
+
+	if e.Type != eventType {
+		return fmt.Errorf("%w: expected a %s event but got %q", ErrInvalidEvent, eventType, e.Type)
+	}
+
+	err := e.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(e.Data, target)
+	if err != nil {
+		return fmt.Errorf("%w: %s data: %w", ErrInvalidEvent, eventType, err)
+	}
+
+	return nil
+}
+
+// The levels log entries can have. Every log event is routed by one of them
+const (
+	LEVEL_DEBUG   = "DEBUG"
+	LEVEL_INFO    = "INFO"
+	LEVEL_WARNING = "WARNING"
+	LEVEL_ERROR   = "ERROR"
+)
+
+// LogLevels is every level log entries can have, least severe first
+var LogLevels = []string{LEVEL_DEBUG, LEVEL_INFO, LEVEL_WARNING, LEVEL_ERROR}
+
+// NormalizeLevel returns the canonical form of a log level, the same as the logger
+// service keeps. Levels are case-insensitive, "WARN" is short for "WARNING", and no
+// level at all means "INFO"
+func NormalizeLevel(level string) (string, error) {
+
+	level = strings.ToUpper(strings.TrimSpace(level))
+
+	switch level {
+	case "":
+		return LEVEL_INFO, nil
+	case "WARN":
+		return LEVEL_WARNING, nil
+	case LEVEL_DEBUG, LEVEL_INFO, LEVEL_WARNING, LEVEL_ERROR:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// LogRoutingKey returns the routing key log events of the given level are published
+// with, like "log.WARNING". There is one for each of LogLevels, so consumers can bind
+// them all. Levels NormalizeLevel doesn't know are routed as INFO, rather than to a
+// key nothing binds
+func LogRoutingKey(level string) string {
+	level, err := NormalizeLevel(level)
+	if err != nil {
+		level = LEVEL_INFO
+	}
+	return TYPE_LOG + "." + level
+}
+
+// AuthRoutingKey returns the routing key an auth event for the given action is
+// published with, like "auth.login"
+func AuthRoutingKey(action string) string {
+	return TYPE_AUTH + "." + action
+}
+
+// NewID generates a random version 4 UUID to identify an event
+func NewID() (string, error) {
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
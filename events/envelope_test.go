@@ -0,0 +1,69 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func Test_Envelope_RoundTrip(t *testing.T) {
+	e, err := NewLogEvent("broker-service", LogData{Name: "event", Data: "some data", Level: "ERROR"}, WithCorrelationID("req-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Type != TYPE_LOG || e.SchemaVersion != 1 || e.ID == "" || e.CorrelationID != "req-1" {
+		t.Fatalf("unexpected envelope %+v", e)
+	}
+
+	body, _ := json.Marshal(e)
+
+	decoded, err := Decode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := decoded.LogData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Name != "event" || data.Level != "ERROR" {
+		t.Errorf("unexpected data %+v", data)
+	}
+
+	if _, err := decoded.MailData(); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected decoding a log event as mail to fail, got %v", err)
+	}
+}
+
+func Test_Decode_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"not json", `oops`, ErrInvalidEvent},
+		{"bare payload", `{"name":"event","data":"some data"}`, ErrInvalidEvent},
+		{"no specversion", `{"id":"1","type":"log","source":"test","schemaversion":1,"data":{}}`, ErrInvalidEvent},
+		{"unknown type", `{"specversion":"1.0","id":"1","type":"sms","source":"test","schemaversion":1,"data":{}}`, ErrUnknownType},
+		{"future version", `{"specversion":"1.0","id":"1","type":"log","source":"test","schemaversion":2,"data":{}}`, ErrUnsupportedVersion},
+		{"no version", `{"specversion":"1.0","id":"1","type":"log","source":"test","data":{}}`, ErrUnsupportedVersion},
+		{"not json data", `{"specversion":"1.0","id":"1","type":"log","source":"test","schemaversion":1,"datacontenttype":"text/plain","data":"hi"}`, ErrInvalidEvent},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.body))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func Test_LogRoutingKey(t *testing.T) {
+	for level, want := range map[string]string{"": "log.INFO", "debug": "log.DEBUG", "warn": "log.WARNING", "ERROR": "log.ERROR", "nonsense": "log.INFO"} {
+		if got := LogRoutingKey(level); got != want {
+			t.Errorf("%q: expected %s but got %s", level, want, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// LOGS_EXCHANGE is the topic exchange every event is published to. Despite the name,
+// it carries auth and mail events as well as log events
+const LOGS_EXCHANGE = "logs_topic"
+
+// MAIL_ROUTING_KEY is the routing key mail jobs are published with, for the mail
+// service to send
+const MAIL_ROUTING_KEY = TYPE_MAIL + ".send"
+
+// DeclareExchange declares the topic exchange events are published to
+func DeclareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		LOGS_EXCHANGE, // Name
+		"topic",       // Type
+		true,          // Durable?
+		false,         // Auto-deleted?
+		false,         // Internal?
+		false,         // No-wait?
+		nil,           // Arguments
+	)
+}
@@ -0,0 +1,5 @@
+module github.com/BlackSound1/go-microservices/events
+
+go 1.23.1
+
+require github.com/rabbitmq/amqp091-go v1.10.0
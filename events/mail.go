@@ -0,0 +1,240 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+const (
+	MAX_ATTACHMENTS      = 20       // How many files can be attached to one mail
+	MAX_ATTACHMENT_SIZE  = 10 << 20 // The biggest a single attachment can be, 10 MB
+	MAX_ATTACHMENTS_SIZE = 25 << 20 // The biggest every attachment on one mail can be together, 25 MB
+
+	// The biggest request carrying a mail can be. Attachments grow by a third when
+	// they're base64 encoded, and the rest of the mail needs some room too
+	MAX_MAIL_REQUEST_SIZE = MAX_ATTACHMENTS_SIZE*4/3 + 1<<20
+
+	// The biggest every attachment on a queued mail can be together, 8 MB. Queued mail
+	// travels through RabbitMQ, which refuses messages over 16 MB by default
+	MAX_QUEUED_ATTACHMENTS_SIZE = 8 << 20
+
+	// The biggest request carrying a mail to be queued can be
+	MAX_QUEUED_MAIL_REQUEST_SIZE = MAX_QUEUED_ATTACHMENTS_SIZE*4/3 + 1<<20
+)
+
+// reservedHeaders can't be set as custom headers, since they're set from the mail's
+// own fields, or by the mail service when it builds the message
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+}
+
+// Addresses is a list of email addresses. In JSON it can be an array, or a single
+// string for just one address
+type Addresses []string
+
+// UnmarshalJSON reads either an array of addresses or a single address
+func (a *Addresses) UnmarshalJSON(b []byte) error {
+
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = nil
+		if single != "" {
+			*a = Addresses{single}
+		}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return fmt.Errorf("addresses must be a string or an array of strings")
+	}
+
+	*a = list
+
+	return nil
+}
+
+// Attachment is a file attached to a mail. In JSON its content is base64 encoded.
+//
+// Attachments with a content ID are inline: the mail's HTML shows them with a
+// reference like <img src="cid:logo">, where "logo" is the content ID
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"` // Guessed from the filename if it isn't given
+	Content     []byte `json:"content"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// FieldError is a problem with a single field of a mail, like an invalid address
+type FieldError struct {
+	Field string `json:"field"` // Which field it is, like "to[1]" or "headers.X-Campaign"
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+// FieldErrors is every problem with a mail's fields. It is returned by Validate, so
+// callers can report each one
+type FieldErrors []FieldError
+
+// Error lists every problem on one line
+func (e FieldErrors) Error() string {
+
+	problems := make([]string, len(e))
+	for i, fe := range e {
+		problems[i] = fmt.Sprintf("%s %q: %s", fe.Field, fe.Value, fe.Error)
+	}
+
+	return "invalid mail: " + strings.Join(problems, "; ")
+}
+
+// Recipients returns every address the mail is sent to, including copies
+func (m MailData) Recipients() []string {
+
+	recipients := make([]string, 0, len(m.To)+len(m.CC)+len(m.BCC))
+	recipients = append(recipients, m.To...)
+	recipients = append(recipients, m.CC...)
+	recipients = append(recipients, m.BCC...)
+
+	return recipients
+}
+
+// Validate makes sure the mail has at least one recipient, that every address is
+// valid, and that its custom headers can be sent. It returns FieldErrors listing
+// every problem it finds
+func (m MailData) Validate() error {
+
+	var problems FieldErrors
+
+	if len(m.Recipients()) == 0 {
+		problems = append(problems, FieldError{Field: "to", Error: "at least one recipient is required"})
+	}
+
+	check := func(field, address string) {
+		_, err := mail.ParseAddress(address)
+		if err != nil {
+			problems = append(problems, FieldError{Field: field, Value: address, Error: strings.TrimPrefix(err.Error(), "mail: ")})
+		}
+	}
+
+	if m.From != "" {
+		check("from", m.From)
+	}
+
+	for _, field := range []struct {
+		name      string
+		addresses Addresses
+	}{{"to", m.To}, {"cc", m.CC}, {"bcc", m.BCC}} {
+		for i, address := range field.addresses {
+			check(fmt.Sprintf("%s[%d]", field.name, i), address)
+		}
+	}
+
+	if m.ReplyTo != "" {
+		check("reply_to", m.ReplyTo)
+	}
+
+	// Go through the headers in order, so problems are always reported the same way
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := "headers." + name
+		value := m.Headers[name]
+
+		switch {
+		case !validHeaderName(name):
+			problems = append(problems, FieldError{Field: field, Value: name, Error: "not a valid header name"})
+		case reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)]:
+			problems = append(problems, FieldError{Field: field, Value: name, Error: "can't be set as a custom header"})
+		case strings.ContainsAny(value, "\r\n"):
+			problems = append(problems, FieldError{Field: field, Value: value, Error: "must not contain line breaks"})
+		}
+	}
+
+	problems = append(problems, validateAttachments(m.Attachments)...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return problems
+}
+
+// validateAttachments makes sure every attachment has a name and some content,
+// and that they aren't too big, alone or together
+func validateAttachments(attachments []Attachment) FieldErrors {
+
+	var problems FieldErrors
+
+	if len(attachments) > MAX_ATTACHMENTS {
+		problems = append(problems, FieldError{Field: "attachments", Error: fmt.Sprintf("no more than %d files can be attached", MAX_ATTACHMENTS)})
+	}
+
+	total := 0
+
+	for i, a := range attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+		total += len(a.Content)
+
+		switch {
+		case a.Filename == "" || strings.ContainsAny(a.Filename, "/\\\r\n"):
+			problems = append(problems, FieldError{Field: field + ".filename", Value: a.Filename, Error: "a plain file name is required"})
+		case len(a.Content) == 0:
+			problems = append(problems, FieldError{Field: field + ".content", Value: a.Filename, Error: "content is required"})
+		case len(a.Content) > MAX_ATTACHMENT_SIZE:
+			problems = append(problems, FieldError{Field: field + ".content", Value: a.Filename, Error: fmt.Sprintf("bigger than %d bytes", MAX_ATTACHMENT_SIZE)})
+		}
+
+		if a.ContentType != "" {
+			if _, _, err := mime.ParseMediaType(a.ContentType); err != nil {
+				problems = append(problems, FieldError{Field: field + ".content_type", Value: a.ContentType, Error: "not a valid MIME type"})
+			}
+		}
+
+		if a.ContentID != "" && strings.ContainsAny(a.ContentID, " <>\"\r\n") {
+			problems = append(problems, FieldError{Field: field + ".content_id", Value: a.ContentID, Error: "must not contain spaces, quotes or angle brackets"})
+		}
+	}
+
+	if total > MAX_ATTACHMENTS_SIZE {
+		problems = append(problems, FieldError{Field: "attachments", Error: fmt.Sprintf("bigger than %d bytes together", MAX_ATTACHMENTS_SIZE)})
+	}
+
+	return problems
+}
+
+// validHeaderName reports whether name is a valid header field name: printable ASCII,
+// with no spaces or colons
+func validHeaderName(name string) bool {
+
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return false
+		}
+	}
+
+	return true
+}
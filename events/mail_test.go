@@ -0,0 +1,112 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func Test_Addresses_UnmarshalJSON(t *testing.T) {
+	var m MailData
+
+	err := json.Unmarshal([]byte(`{"to":"a@example.com","cc":["b@example.com","c@example.com"]}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.To) != 1 || len(m.CC) != 2 || len(m.Recipients()) != 3 {
+		t.Errorf("unexpected addresses %+v", m)
+	}
+
+	if err := json.Unmarshal([]byte(`{"to":42}`), &m); err == nil {
+		t.Error("expected a number to be refused")
+	}
+}
+
+func Test_MailData_Validate(t *testing.T) {
+	m := MailData{
+		To:      Addresses{"you@example.com", "not an address"},
+		BCC:     Addresses{"Boss <boss@example.com>"},
+		ReplyTo: "nope",
+		Headers: map[string]string{"X-Campaign": "spring", "Bcc": "sneaky@example.com", "X-Evil": "a\r\nBcc: x@example.com"},
+	}
+
+	err := m.Validate()
+
+	var problems FieldErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected FieldErrors but got %v", err)
+	}
+
+	want := []string{"to[1]", "reply_to", "headers.Bcc", "headers.X-Evil"}
+	if len(problems) != len(want) {
+		t.Fatalf("expected problems with %v but got %+v", want, problems)
+	}
+
+	for i, field := range want {
+		if problems[i].Field != field {
+			t.Errorf("expected a problem with %s but got %+v", field, problems[i])
+		}
+	}
+
+	if err := (MailData{}).Validate(); err == nil {
+		t.Error("expected a mail with no recipients to be invalid")
+	}
+}
+
+func Test_MailData_Validate_Attachments(t *testing.T) {
+	var m MailData
+
+	err := json.Unmarshal([]byte(`{"to":"a@example.com","attachments":[{"filename":"hi.txt","content":"aGk="}]}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Attachments) != 1 || string(m.Attachments[0].Content) != "hi" {
+		t.Fatalf("expected the content to be base64 decoded but got %+v", m.Attachments)
+	}
+
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected a valid mail but got %v", err)
+	}
+
+	m.Attachments = []Attachment{
+		{Filename: "../etc/passwd", Content: []byte("x")},
+		{Filename: "empty.txt"},
+		{Filename: "big.bin", Content: make([]byte, MAX_ATTACHMENT_SIZE+1)},
+		{Filename: "logo.png", ContentType: "image/", Content: []byte("x"), ContentID: "<logo>"},
+	}
+
+	var problems FieldErrors
+	if !errors.As(m.Validate(), &problems) {
+		t.Fatal("expected FieldErrors")
+	}
+
+	want := []string{"attachments[0].filename", "attachments[1].content", "attachments[2].content", "attachments[3].content_type", "attachments[3].content_id"}
+	if len(problems) != len(want) {
+		t.Fatalf("expected problems with %v but got %+v", want, problems)
+	}
+
+	for i, field := range want {
+		if problems[i].Field != field {
+			t.Errorf("expected a problem with %s but got %+v", field, problems[i])
+		}
+	}
+}
+
+func Test_NewMailEvent_Attachments(t *testing.T) {
+	m := MailData{
+		To:          Addresses{"a@example.com"},
+		Attachments: []Attachment{{Filename: "big.bin", Content: make([]byte, MAX_ATTACHMENT_SIZE)}},
+	}
+
+	// Fine to send, but too big to queue
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var problems FieldErrors
+	if _, err := NewMailEvent("broker-service", m); !errors.As(err, &problems) || problems[0].Field != "attachments" {
+		t.Errorf("expected the attachments to be too big to queue but got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+// Package fileutil holds the file helpers services share
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic replaces the file at path with data. The data is written to a
+// temporary file in the same directory and synced first, then renamed over the
+// file, so a crash part way through leaves either the old file or the new one,
+// never half of one
+func WriteAtomic(path string, data []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Does nothing once it has been renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
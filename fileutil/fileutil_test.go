@@ -0,0 +1,34 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.json")
+
+	for _, data := range []string{"first", "second"} {
+		err := WriteAtomic(path, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil || string(b) != data {
+			t.Fatalf("expected %q but got %q (%v)", data, b, err)
+		}
+	}
+
+	// Nothing is left behind but the file itself
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the file to be left but got %d entries", len(entries))
+	}
+
+	if err := WriteAtomic(filepath.Join(dir, "missing", "file.json"), nil); err == nil {
+		t.Error("expected an error writing to a directory that doesn't exist")
+	}
+}
@@ -15,6 +15,10 @@ func main() {
 		render(w, "test.page.gohtml")
 	})
 
+	http.HandleFunc("/mail", func(w http.ResponseWriter, r *http.Request) {
+		render(w, "mail.page.gohtml")
+	})
+
 	fmt.Println("Starting front end service on port 8081")
 
 	err := http.ListenAndServe(":8081", nil)
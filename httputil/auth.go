@@ -0,0 +1,135 @@
+// Package httputil holds the HTTP helpers services share, apart from the events they
+// send each other: checking access tokens with the auth service, and Server-Sent Events
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AUTH_VALIDATE_URL = "http://auth-service/validate" // Where the auth service checks access tokens
+	AUTH_TIMEOUT      = 10 * time.Second               // How long to wait for the auth service to answer
+
+	// ADMIN_KEY_HEADER carries the key one service shares with another to call its
+	// admin endpoints, like the mail service's suppression list
+	ADMIN_KEY_HEADER = "X-Admin-Key"
+)
+
+// ErrUnauthorized is returned for requests without an access token, or with one the
+// auth service doesn't accept
+var ErrUnauthorized = errors.New("invalid token")
+
+// ErrForbidden is returned for valid access tokens that don't belong to an active admin
+var ErrForbidden = errors.New("admin access required")
+
+// ErrAuthUnavailable is returned when the auth service can't be asked about a token
+var ErrAuthUnavailable = errors.New("error calling auth service")
+
+// authClient is shared by every token check, so connections to the auth service are reused
+var authClient = &http.Client{Timeout: AUTH_TIMEOUT}
+
+// TokenClaims is what the auth service says about a valid access token. Raw is every
+// claim, as the auth service sent them
+type TokenClaims struct {
+	UserID int             `json:"uid"`
+	Email  string          `json:"email"`
+	Active bool            `json:"active"`
+	Admin  bool            `json:"admin"`
+	Raw    json.RawMessage `json:"-"`
+}
+
+// BearerToken returns the token from the request's "Authorization: Bearer" header,
+// or an empty string if there isn't one
+func BearerToken(r *http.Request) string {
+
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// ValidateToken asks the auth service at the given URL, normally AUTH_VALIDATE_URL,
+// whether an access token is valid, and returns its claims if it is. Errors wrap
+// ErrUnauthorized if it isn't, and ErrAuthUnavailable if the auth service couldn't say
+func ValidateToken(ctx context.Context, url, token string) (TokenClaims, error) {
+
+	if token == "" {
+		return TokenClaims{}, fmt.Errorf("%w: authorization required", ErrUnauthorized)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := authClient.Do(request)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		return TokenClaims{}, ErrUnauthorized
+	} else if response.StatusCode != http.StatusOK {
+		return TokenClaims{}, fmt.Errorf("%w: responded with status %d", ErrAuthUnavailable, response.StatusCode)
+	}
+
+	var jsonFromService struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+	}
+
+	var claims TokenClaims
+
+	err = json.Unmarshal(jsonFromService.Data, &claims)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+	}
+	claims.Raw = jsonFromService.Data
+
+	return claims, nil
+}
+
+// RequireAdmin is ValidateToken for tokens that must belong to an active admin. If
+// the token is valid but doesn't, the error wraps ErrForbidden
+func RequireAdmin(ctx context.Context, url, token string) (TokenClaims, error) {
+
+	claims, err := ValidateToken(ctx, url, token)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	if !claims.Active || !claims.Admin {
+		return TokenClaims{}, ErrForbidden
+	}
+
+	return claims, nil
+}
+
+// AuthStatus returns the HTTP status code to refuse a request with, for an error from
+// ValidateToken or RequireAdmin
+func AuthStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAuthUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
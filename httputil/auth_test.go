@@ -0,0 +1,37 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RequireAdmin(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer admin":
+			_, _ = w.Write([]byte(`{"error":false,"data":{"uid":1,"email":"admin@example.com","active":true,"admin":true,"exp":1}}`))
+		case "Bearer user":
+			_, _ = w.Write([]byte(`{"error":false,"data":{"uid":2,"email":"user@example.com","active":true,"admin":false}}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer auth.Close()
+
+	claims, err := RequireAdmin(context.Background(), auth.URL, "admin")
+	if err != nil || claims.UserID != 1 || string(claims.Raw) == "" {
+		t.Fatalf("expected the admin's claims but got %+v (%v)", claims, err)
+	}
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "nonsense": http.StatusUnauthorized, "user": http.StatusForbidden} {
+		if _, err := RequireAdmin(context.Background(), auth.URL, token); AuthStatus(err) != want {
+			t.Errorf("%q: expected %d but got %v", token, want, err)
+		}
+	}
+
+	if _, err := ValidateToken(context.Background(), "http://127.0.0.1:1", "admin"); AuthStatus(err) != http.StatusBadGateway {
+		t.Errorf("expected the auth service to be unavailable but got %v", err)
+	}
+}
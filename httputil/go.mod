@@ -0,0 +1,3 @@
+module github.com/BlackSound1/go-microservices/httputil
+
+go 1.23.1
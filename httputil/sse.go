@@ -0,0 +1,38 @@
+package httputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SSE_HEARTBEAT is how often an idle event stream is sent a comment to keep it open
+const SSE_HEARTBEAT = 15 * time.Second
+
+// WriteEvent writes a single Server-Sent Event, with the given data encoded as JSON.
+// The event ID is left out if it is empty
+func WriteEvent(w io.Writer, event, id string, data any) error {
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, out)
+	return err
+}
+
+// WriteHeartbeat writes a comment line to an event stream, which clients ignore but
+// which keeps proxies from timing out a quiet stream
+func WriteHeartbeat(w io.Writer) error {
+	_, err := fmt.Fprint(w, ": heartbeat\n\n")
+	return err
+}
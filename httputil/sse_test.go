@@ -0,0 +1,30 @@
+package httputil
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_WriteEvent(t *testing.T) {
+	var b strings.Builder
+
+	err := WriteEvent(&b, "log", "1", map[string]string{"name": "event"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteEvent(&b, "dropped", "", map[string]int{"dropped": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteHeartbeat(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id: 1\nevent: log\ndata: {\"name\":\"event\"}\n\nevent: dropped\ndata: {\"dropped\":2}\n\n: heartbeat\n\n"
+	if b.String() != want {
+		t.Errorf("expected %q but got %q", want, b.String())
+	}
+}
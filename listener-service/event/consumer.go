@@ -1,67 +1,103 @@
 package event
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"sync"
+	"time"
 
+	"github.com/BlackSound1/go-microservices/events"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	DEFAULT_WORKERS    = 10                       // How many events are handled at once, unless told otherwise
+	HANDLER_TIMEOUT    = 30 * time.Second         // How long a handler has to handle an event
+	RETRIES_HEADER     = "x-retries"              // How many times an event has been retried
+	ROUTING_KEY_HEADER = "x-original-routing-key" // The routing key a retried event was first published with
+)
+
+// STALE_TOPICS are topics older versions of the listener bound the logs queue to, and
+// which are unbound on every connection. Mail jobs have their own queue now, which the
+// mail service consumes, so keeping "mail.#" bound would send every mail twice
+var STALE_TOPICS = []string{"mail.#"}
+
+// ErrRejected is wrapped by errors from handling an event that will fail however
+// many times it is retried
+var ErrRejected = errors.New("event rejected")
+
 type Consumer struct {
-	conn      *amqp.Connection
+	conn      *events.Connection
+	handlers  *HandlerRegistry
+	workers   int
 	queueName string
 }
 
-type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
-}
+// NewConsumer creates a new Consumer on the given Connection, which passes events to
+// the given handlers, handling up to workers events at once. If workers isn't
+// positive, DEFAULT_WORKERS are used. Nothing is consumed until Listen is called.
+func NewConsumer(conn *events.Connection, handlers *HandlerRegistry, workers int) (Consumer, error) {
+	if conn == nil {
+		return Consumer{}, errors.New("a connection is required")
+	}
 
-// NewConsumer creates a new Consumer by setting up the RabbitMQ connection and
-// declaring the topic exchange.
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
-	consumer := Consumer{conn: conn}
+	if handlers == nil {
+		return Consumer{}, errors.New("handlers are required")
+	}
 
-	err := consumer.setup()
-	if err != nil {
-		return Consumer{}, err
+	if workers <= 0 {
+		workers = DEFAULT_WORKERS
 	}
 
-	return consumer, nil
+	return Consumer{conn: conn, handlers: handlers, workers: workers}, nil
 }
 
-// setup sets up the RabbitMQ consumer by declaring the exchange.
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
-	return declareExchange(channel)
+// Listen consumes events from the durable logs queue, which it binds to the given
+// topics, and passes each one to its handler. Events are read in either CloudEvents
+// mode, whichever they were published in. It must be called before the
+// Connection is started: the exchanges, queues and bindings are declared every time
+// the Connection connects, and consuming resumes on each new connection.
+//
+// Events are only acknowledged once they have been handled. Events that fail are
+// retried after RETRY_DELAY, up to MAX_RETRIES times, and events that can never be
+// handled, have no handler, or run out of retries, are dead-lettered.
+func (consumer *Consumer) Listen(topics []string) {
+	consumer.conn.OnConnect(func(conn *amqp.Connection) error {
+		return consumer.consume(conn, topics)
+	})
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
+// consume sets up the exchanges and queues on a new connection, and starts handling
+// events from the logs queue in the background, until the connection is lost
+func (consumer *Consumer) consume(conn *amqp.Connection, topics []string) error {
 
 	// Try to get the channel
-	ch, err := consumer.conn.Channel()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	// Declare the exchange and queues, which might not have survived whatever
+	// happened to the last connection
+	err = events.DeclareExchange(ch)
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
 
-	// Try to get the queue
-	q, err := declareRandomQueue(ch)
+	q, err := declareQueues(ch)
 	if err != nil {
 		return err
 	}
+	consumer.queueName = q.Name
 
 	// Go through each topic and bind it to the queue
 	for _, s := range topics {
 		err := ch.QueueBind(
 			q.Name,
 			s,
-			"logs_topic",
+			events.LOGS_EXCHANGE,
 			false,
 			nil,
 		)
@@ -71,10 +107,25 @@ func (consumer *Consumer) Listen(topics []string) error {
 		}
 	}
 
+	// The queue is durable, so bindings it no longer needs outlive the listener
+	// versions that made them. Unbinding one that isn't there does nothing
+	for _, s := range STALE_TOPICS {
+		err := ch.QueueUnbind(q.Name, s, events.LOGS_EXCHANGE, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only take as many unacknowledged events as there are workers to handle them
+	err = ch.Qos(consumer.workers, 0, false)
+	if err != nil {
+		return err
+	}
+
 	messages, err := ch.Consume(
 		q.Name, // Queue name
 		"",     // Consumer name
-		true,   // Auto-ack?
+		false,  // Auto-ack?
 		false,  // Exclusive?
 		false,  // No local?
 		false,  // No wait?
@@ -84,74 +135,141 @@ func (consumer *Consumer) Listen(topics []string) error {
 		return err
 	}
 
-	// Create a channel that runs in the background forever
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload Payload
+	fmt.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", events.LOGS_EXCHANGE, q.Name)
 
-			_ = json.Unmarshal(d.Body, &payload)
+	// Handle events on a fixed pool of workers. Deliveries stop when the connection
+	// is lost, and the channel is closed once every worker has finished
+	var wg sync.WaitGroup
+	for range consumer.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-			go handlePayload(payload)
+			for d := range messages {
+				consumer.handleDelivery(ch, d)
+			}
+		}()
+	}
 
-		}
+	go func() {
+		wg.Wait()
+		_ = ch.Close()
 	}()
 
-	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
-	<-forever
-
 	return nil
 }
 
-// handlePayload takes a payload and handles it in various ways depending on its type
-func handlePayload(payload Payload) {
-	switch payload.Name {
-	case "log", "event":
-		// Log whatever we get
-		err := logEvent(payload)
-		if err != nil {
-			fmt.Println(err)
-		}
-	case "auth":
-		// Authenticate
+// handleDelivery handles a single event, then acknowledges it, schedules it to be
+// retried, or dead-letters it
+func (consumer *Consumer) handleDelivery(ch *amqp.Channel, d amqp.Delivery) {
 
-	default:
-		err := logEvent(payload)
-		if err != nil {
-			fmt.Println(err)
-		}
+	// Events that aren't valid envelopes, or are versions we don't understand, will
+	// never be handled, so dead-letter them straight away
+	event, err := events.DecodeDelivery(d)
+	if err != nil {
+		log.Printf("Dead-lettering malformed event %q: %v", d.MessageId, err)
+		_ = d.Nack(false, false)
+		return
 	}
-}
 
-// logEvent sends the given payload entry to the log service as a JSON object.
-// It performs an HTTP POST request to the log service URL and checks for a successful response.
-func logEvent(entry Payload) error {
-	// Convert given entry into JSON
-	jsonData, _ := json.MarshalIndent(entry, "", "\t")
+	routingKey := originalRoutingKey(d)
 
-	logServiceURL := "http://logger-service/log"
+	err = consumer.handleEvent(event, routingKey)
+	if err == nil {
+		_ = d.Ack(false)
+		return
+	}
 
-	// Create a new request to the log service
-	req, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	// Events that were rejected will be rejected again, so don't bother retrying them
+	if errors.Is(err, ErrRejected) {
+		log.Printf("Dead-lettering rejected %s event %s: %v", event.Type, event.ID, err)
+		_ = d.Nack(false, false)
+		return
 	}
 
-	// Set the necessary header
-	req.Header.Set("Content-Type", "application/json")
+	// Try again later, unless we've already tried too many times
+	retries := retryCount(d)
+	if retries >= MAX_RETRIES {
+		log.Printf("Dead-lettering %s event %s after %d retries: %v", event.Type, event.ID, retries, err)
+		_ = d.Nack(false, false)
+		return
+	}
+
+	log.Printf("Retrying %s event %s in %s (retry %d of %d): %v", event.Type, event.ID, RETRY_DELAY, retries+1, MAX_RETRIES, err)
 
-	// Perform the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	err = scheduleRetry(ch, d, routingKey, retries+1)
 	if err != nil {
-		return err
+		// Put it back on the queue rather than lose it
+		log.Println("Error scheduling retry:", err)
+		_ = d.Nack(false, true)
+		return
 	}
-	defer resp.Body.Close()
 
-	// Check status code
-	if resp.StatusCode != http.StatusAccepted {
-		return err
+	_ = d.Ack(false)
+}
+
+// handleEvent passes an event to its handler
+func (consumer *Consumer) handleEvent(event events.Envelope, routingKey string) error {
+
+	handler, ok := consumer.handlers.Get(event, routingKey)
+	if !ok {
+		return fmt.Errorf("%w: no handler for %s events", ErrRejected, event.Type)
 	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), HANDLER_TIMEOUT)
+	defer cancel()
+
+	return handler(ctx, event, routingKey)
+}
+
+// scheduleRetry publishes a copy of the given event to the retry exchange, recording
+// which retry it is and the routing key it was first published with
+func scheduleRetry(ch *amqp.Channel, d amqp.Delivery, routingKey string, retries int) error {
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[RETRIES_HEADER] = int32(retries)
+	headers[ROUTING_KEY_HEADER] = routingKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return ch.PublishWithContext(
+		ctx,
+		RETRY_EXCHANGE, // Exchange
+		routingKey,     // Routing key, which the retry exchange ignores
+		false,          // Mandatory?
+		false,          // Immediate?
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		},
+	)
+}
+
+// retryCount returns how many times the given event has been retried
+func retryCount(d amqp.Delivery) int {
+	switch n := d.Headers[RETRIES_HEADER].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// originalRoutingKey returns the routing key the given event was first published
+// with. Retried events come back from the retry queue with a different one
+func originalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[ROUTING_KEY_HEADER].(string); ok {
+		return key
+	}
+	return d.RoutingKey
 }
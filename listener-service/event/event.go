@@ -1,30 +1,104 @@
 package event
 
 import (
+	"time"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// declareExchange declares a topic exchange for logs
-func declareExchange(ch *amqp.Channel) error {
-	return ch.ExchangeDeclare(
-		"logs_topic", // Name
-		"topic",      // Type
-		true,         // Durable?
-		false,        // Auto-deleted?
-		false,        // Internal?
-		false,        // No-wait?
-		nil,          // Arguments
+const (
+	RETRY_EXCHANGE       = "logs_retry"       // Where events waiting to be retried are sent
+	DEAD_LETTER_EXCHANGE = "logs_dead_letter" // Where events that can't be handled end up
+
+	LOGS_QUEUE        = "logs"       // Holds events until they are handled
+	RETRY_QUEUE       = "logs.retry" // Holds events until it is time to retry them
+	DEAD_LETTER_QUEUE = "logs.dead"  // Holds events that can't be handled, for someone to look at
+
+	RETRY_DELAY = 10 * time.Second // How long to wait before retrying an event
+	MAX_RETRIES = 5                // How many times to retry an event before giving up on it
+)
+
+// declareQueues declares the durable queue events are consumed from, along with
+// the queues and exchanges used to retry them and to dead-letter them:
+//
+//   - Events that fail are published to the retry exchange, which routes them to the
+//     retry queue. Once they have waited there for RETRY_DELAY they expire, and are
+//     dead-lettered straight back onto the logs queue.
+//   - Events that can't be handled, or have run out of retries, are rejected, and the
+//     logs queue dead-letters them to the dead letter queue.
+func declareQueues(ch *amqp.Channel) (amqp.Queue, error) {
+
+	// Declare the exchanges
+	for _, name := range []string{RETRY_EXCHANGE, DEAD_LETTER_EXCHANGE} {
+		err := ch.ExchangeDeclare(
+			name,     // Name
+			"fanout", // Type
+			true,     // Durable?
+			false,    // Auto-deleted?
+			false,    // Internal?
+			false,    // No-wait?
+			nil,      // Arguments
+		)
+		if err != nil {
+			return amqp.Queue{}, err
+		}
+	}
+
+	// Declare the dead letter queue
+	_, err := declareQueue(ch, DEAD_LETTER_QUEUE, DEAD_LETTER_EXCHANGE, nil)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	// Declare the retry queue, which sends events back to the logs queue once they expire
+	_, err = declareQueue(ch, RETRY_QUEUE, RETRY_EXCHANGE, amqp.Table{
+		"x-message-ttl":             RETRY_DELAY.Milliseconds(),
+		"x-dead-letter-exchange":    "", // The default exchange, which routes by queue name
+		"x-dead-letter-routing-key": LOGS_QUEUE,
+	})
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	// Declare the logs queue itself
+	return ch.QueueDeclare(
+		LOGS_QUEUE, // Name
+		true,       // Durable?
+		false,      // Auto-deleted?
+		false,      // Exclusive?
+		false,      // No-wait?
+		amqp.Table{ // Arguments
+			"x-dead-letter-exchange": DEAD_LETTER_EXCHANGE,
+		},
 	)
 }
 
-// declareRandomQueue declares a random queue
-func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
-	return ch.QueueDeclare(
-		"",    // Name
-		false, // Durable?
+// declareQueue declares a durable queue with the given arguments, and binds it to
+// the given fanout exchange
+func declareQueue(ch *amqp.Channel, name, exchange string, args amqp.Table) (amqp.Queue, error) {
+
+	q, err := ch.QueueDeclare(
+		name,  // Name
+		true,  // Durable?
 		false, // Auto-deleted?
-		true,  // Exclusive?
+		false, // Exclusive?
 		false, // No-wait?
-		nil,   // Arguments
+		args,  // Arguments
 	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	err = ch.QueueBind(
+		q.Name,   // Queue name
+		"",       // Routing key, which fanout exchanges ignore
+		exchange, // Exchange name
+		false,    // No-wait?
+		nil,      // Arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	return q, nil
 }
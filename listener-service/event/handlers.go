@@ -0,0 +1,211 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+const (
+	SOURCE          = "listener-service"          // The source of events the listener creates itself
+	LOGGER_URL      = "http://logger-service/log" // Where log entries are written
+	REQUEST_TIMEOUT = 10 * time.Second            // How long to wait for another service to respond
+)
+
+// client is shared by every handler, so connections to other services are reused
+var client = &http.Client{Timeout: REQUEST_TIMEOUT}
+
+// Handler handles a single event, which arrived with the given routing key. Errors
+// wrapping ErrRejected mean the event will never be handled, and it is dead-lettered.
+// Any other error is retried
+type Handler func(ctx context.Context, event events.Envelope, routingKey string) error
+
+// HandlerRegistry holds the handler for each type of event. A handler can also be
+// registered for a single routing key, which takes precedence over its event type
+type HandlerRegistry struct {
+	mu           sync.RWMutex
+	byType       map[string]Handler
+	byRoutingKey map[string]Handler
+}
+
+// NewHandlerRegistry returns an empty HandlerRegistry
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		byType:       make(map[string]Handler),
+		byRoutingKey: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for every event of the given type. It returns an error
+// if the type already has one
+func (reg *HandlerRegistry) Register(eventType string, h Handler) error {
+	return reg.register(reg.byType, "type", eventType, h)
+}
+
+// RegisterRoutingKey sets the handler for events published with the given routing
+// key, like "log.ERROR". It returns an error if the routing key already has one
+func (reg *HandlerRegistry) RegisterRoutingKey(key string, h Handler) error {
+	return reg.register(reg.byRoutingKey, "routing key", key, h)
+}
+
+// register adds a handler to one of the registry's maps
+func (reg *HandlerRegistry) register(handlers map[string]Handler, kind, name string, h Handler) error {
+
+	if name == "" || h == nil {
+		return fmt.Errorf("a handler needs a %s and a function", kind)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, exists := handlers[name]; exists {
+		return fmt.Errorf("%s %q already has a handler", kind, name)
+	}
+
+	handlers[name] = h
+
+	return nil
+}
+
+// Get looks up the handler for an event published with the given routing key
+func (reg *HandlerRegistry) Get(event events.Envelope, routingKey string) (Handler, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	if h, ok := reg.byRoutingKey[routingKey]; ok {
+		return h, true
+	}
+
+	h, ok := reg.byType[event.Type]
+	return h, ok
+}
+
+// DefaultHandlers returns a registry with a handler for each type of event the
+// listener handles. Mail jobs aren't among them, since the mail service consumes
+// those itself. Log entries are forwarded to the logger service at loggerURL,
+// normally LOGGER_URL, as CloudEvents in the given mode
+func DefaultHandlers(loggerURL string, mode events.Mode) (*HandlerRegistry, error) {
+
+	reg := NewHandlerRegistry()
+
+	err := errors.Join(
+		reg.Register(events.TYPE_LOG, LogHandler(loggerURL, mode)),
+		reg.Register(events.TYPE_AUTH, AuthHandler(loggerURL, mode)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// LogHandler forwards log events to the logger service at the given URL. Entries
+// without a level take it from their routing key, e.g. "log.WARNING"
+func LogHandler(loggerURL string, mode events.Mode) Handler {
+	return func(ctx context.Context, event events.Envelope, routingKey string) error {
+
+		entry, err := event.LogData()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+
+		if entry.Level == "" {
+			entry.Level = levelFromRoutingKey(routingKey)
+
+			event.Data, err = json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrRejected, err)
+			}
+		}
+
+		// Log whatever we get
+		return logEvent(ctx, loggerURL, event, mode)
+	}
+}
+
+// AuthHandler records an audit entry in the logs, at the logger service at the given
+// URL, for every auth event, like a user logging in. The entry is tied to the auth
+// event by its correlation ID
+func AuthHandler(loggerURL string, mode events.Mode) Handler {
+	return func(ctx context.Context, event events.Envelope, routingKey string) error {
+
+		auth, err := event.AuthData()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+
+		correlationID := event.CorrelationID
+		if correlationID == "" {
+			correlationID = event.ID
+		}
+
+		audit, err := events.NewLogEvent(SOURCE, events.LogData{
+			Name:    "audit." + auth.Action,
+			Data:    fmt.Sprintf("%s: %s", auth.Action, auth.Email),
+			Level:   "INFO",
+			Source:  event.Source,
+			TraceID: correlationID,
+			Attributes: map[string]any{
+				"action":   auth.Action,
+				"user_id":  auth.UserID,
+				"email":    auth.Email,
+				"event_id": event.ID,
+				"at":       event.Time,
+			},
+		}, events.WithCorrelationID(correlationID))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+
+		return logEvent(ctx, loggerURL, audit, mode)
+	}
+}
+
+// logEvent forwards the given log event to the log service at the given URL as a
+// CloudEvent, in the given mode.
+func logEvent(ctx context.Context, loggerURL string, event events.Envelope, mode events.Mode) error {
+
+	req, err := event.NewRequest(ctx, http.MethodPost, loggerURL, mode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+
+	return send(req, "logger service")
+}
+
+// send performs a request to another service and checks for a successful response.
+// The service rejecting the request won't change by retrying, so 4xx responses are
+// returned as ErrRejected
+func send(req *http.Request, service string) error {
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: %s responded with status %d", ErrRejected, service, resp.StatusCode)
+	} else if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%s responded with status %d", service, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// levelFromRoutingKey returns the severity part of a "log.<LEVEL>" routing key,
+// or an empty string if the key doesn't have one
+func levelFromRoutingKey(key string) string {
+	_, level, found := strings.Cut(key, ".")
+	if !found {
+		return ""
+	}
+	return level
+}
@@ -0,0 +1,148 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+func Test_HandlerRegistry(t *testing.T) {
+	reg := NewHandlerRegistry()
+
+	var called string
+	handler := func(name string) Handler {
+		return func(ctx context.Context, event events.Envelope, routingKey string) error {
+			called = name
+			return nil
+		}
+	}
+
+	if err := reg.Register(events.TYPE_LOG, handler("log")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reg.RegisterRoutingKey("log.ERROR", handler("errors")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reg.Register(events.TYPE_LOG, handler("again")); err == nil {
+		t.Error("expected registering a type twice to fail")
+	}
+
+	event := events.Envelope{Type: events.TYPE_LOG}
+
+	for key, want := range map[string]string{"log.INFO": "log", "log.ERROR": "errors"} {
+		h, ok := reg.Get(event, key)
+		if !ok {
+			t.Fatalf("%s: expected a handler", key)
+		}
+
+		_ = h(context.Background(), event, key)
+		if called != want {
+			t.Errorf("%s: expected the %s handler but got %s", key, want, called)
+		}
+	}
+
+	if _, ok := reg.Get(events.Envelope{Type: events.TYPE_MAIL}, "mail.send"); ok {
+		t.Error("expected no handler for mail events")
+	}
+}
+
+// fakeLogger is a logger service that records the events posted to it, and answers
+// with the given status
+type fakeLogger struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	events []events.Envelope
+}
+
+func newFakeLogger(t *testing.T) *fakeLogger {
+	logger := &fakeLogger{status: http.StatusAccepted}
+
+	logger.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event, err := events.DecodeRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+
+		logger.events = append(logger.events, event)
+		w.WriteHeader(logger.status)
+	}))
+	t.Cleanup(logger.Close)
+
+	return logger
+}
+
+func Test_LogHandler(t *testing.T) {
+	logger := newFakeLogger(t)
+	handler := LogHandler(logger.URL, events.MODE_BINARY)
+
+	event, err := events.NewLogEvent("broker-service", events.LogData{Name: "event", Data: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = handler(context.Background(), event, "log.WARNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries without a level take it from their routing key
+	entry, err := logger.events[0].LogData()
+	if err != nil || entry.Name != "event" || entry.Level != "WARNING" {
+		t.Errorf("expected the entry to be logged as a warning but got %+v (%v)", entry, err)
+	}
+
+	// The logger refusing an entry won't change, but it failing might
+	logger.status = http.StatusBadRequest
+	if err := handler(context.Background(), event, "log.INFO"); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected ErrRejected but got %v", err)
+	}
+
+	logger.status = http.StatusInternalServerError
+	if err := handler(context.Background(), event, "log.INFO"); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("expected an error to retry but got %v", err)
+	}
+
+	// Events that aren't log entries are never handled
+	if err := handler(context.Background(), events.Envelope{Type: events.TYPE_AUTH}, "log.INFO"); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected ErrRejected but got %v", err)
+	}
+}
+
+func Test_AuthHandler(t *testing.T) {
+	logger := newFakeLogger(t)
+	handler := AuthHandler(logger.URL, events.MODE_STRUCTURED)
+
+	event, err := events.NewAuthEvent("auth-service", events.AuthData{Action: "login", UserID: 1, Email: "you@example.com"}, events.WithCorrelationID("request-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = handler(context.Background(), event, "auth.login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The audit entry is tied to the auth event
+	audit := logger.events[0]
+	if audit.Source != SOURCE || audit.CorrelationID != "request-1" {
+		t.Errorf("expected an audit event from the listener but got %+v", audit)
+	}
+
+	entry, err := audit.LogData()
+	if err != nil || entry.Name != "audit.login" || entry.Source != "auth-service" || entry.TraceID != "request-1" || entry.Attributes["event_id"] != event.ID {
+		t.Errorf("expected an audit entry for the login but got %+v (%v)", entry, err)
+	}
+}
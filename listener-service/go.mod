@@ -2,4 +2,8 @@ module github.com/BlackSound1/go-microservices/listener
 
 go 1.23.1
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require github.com/rabbitmq/amqp091-go v1.10.0
+
+require github.com/BlackSound1/go-microservices/events v0.0.0
+
+replace github.com/BlackSound1/go-microservices/events => ../events
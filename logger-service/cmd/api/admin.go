@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/logger/data"
+)
+
+// purgePayload says which log entries to purge
+type purgePayload struct {
+	Before string `json:"before"` // RFC 3339
+	Name   string `json:"name,omitempty"`
+	Level  string `json:"level,omitempty"`
+}
+
+// PurgeResult says how many log entries a purge matched or deleted
+type PurgeResult struct {
+	Before  time.Time `json:"before"`
+	Name    string    `json:"name,omitempty"`
+	Level   string    `json:"level,omitempty"`
+	Matched int64     `json:"matched"`
+	Deleted int64     `json:"deleted"`
+}
+
+// PreviewPurge says how many log entries a purge would delete, without deleting
+// anything. It takes the same "before", "name" and "level" as RunPurge, as query
+// parameters
+func (app *Config) PreviewPurge(w http.ResponseWriter, r *http.Request) {
+
+	params := r.URL.Query()
+
+	filter, err := purgeFilter(purgePayload{
+		Before: params.Get("before"),
+		Name:   params.Get("name"),
+		Level:  params.Get("level"),
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	matched, err := app.Models.LogEntry.CountPurgeable(filter)
+	if errors.Is(err, data.ErrInvalidQuery) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "purge preview",
+		Data: PurgeResult{
+			Before:  filter.Before,
+			Name:    filter.Name,
+			Level:   filter.Level,
+			Matched: matched,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, response)
+}
+
+// RunPurge deletes every log entry created before the given cutoff, optionally only
+// those with a given name or level
+func (app *Config) RunPurge(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload purgePayload
+
+	// Read the request JSON
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter, err := purgeFilter(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	deleted, err := app.Models.LogEntry.Purge(filter)
+	if errors.Is(err, data.ErrInvalidQuery) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "purged",
+		Data: PurgeResult{
+			Before:  filter.Before,
+			Name:    filter.Name,
+			Level:   filter.Level,
+			Matched: deleted,
+			Deleted: deleted,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, response)
+}
+
+// purgeFilter converts a purge payload into a filter, making sure it has a cutoff
+func purgeFilter(p purgePayload) (data.PurgeFilter, error) {
+
+	if p.Before == "" {
+		return data.PurgeFilter{}, errors.New("before is required")
+	}
+
+	before, err := time.Parse(time.RFC3339, p.Before)
+	if err != nil {
+		return data.PurgeFilter{}, errors.New("before must be an RFC 3339 time")
+	}
+
+	return data.PurgeFilter{
+		Before: before,
+		Name:   p.Name,
+		Level:  p.Level,
+	}, nil
+}
@@ -2,13 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"time"
 
 	"github.com/BlackSound1/go-microservices/logger/data"
 	"github.com/BlackSound1/go-microservices/logger/logs"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// STREAM_BATCH_SIZE is how many streamed log entries are buffered before they
+	// are written to the database together
+	STREAM_BATCH_SIZE = 100
+
+	// GRPC_MIN_PING_INTERVAL is how often clients may ping an idle connection to keep
+	// it alive. The broker pings every 30 seconds, even without any streams open
+	GRPC_MIN_PING_INTERVAL = 20 * time.Second
 )
 
 type LogServer struct {
@@ -21,12 +40,15 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 
 	input := req.GetLogEntry()
 
-	// Write the log
-	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+	// Reject bad levels before trying to write anything
+	_, err := data.NormalizeLevel(input.GetLevel())
+	if err != nil {
+		res := &logs.LogResponse{Result: "failed"}
+		return res, status.Error(codes.InvalidArgument, err.Error())
 	}
-	err := l.Models.LogEntry.Insert(logEntry)
+
+	// Write the log
+	err = l.Models.LogEntry.Insert(logEntryFromProto(input))
 	if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
 		return res, err
@@ -37,6 +59,156 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	return res, nil
 }
 
+// WriteLogs writes a batch of log entries to the database in one go. If any
+// entry is invalid, none of them are written
+func (l *LogServer) WriteLogs(ctx context.Context, req *logs.LogsRequest) (*logs.LogsResponse, error) {
+
+	// Convert the entries, rejecting bad levels before trying to write anything
+	entries := make([]data.LogEntry, 0, len(req.GetLogEntries()))
+	for i, input := range req.GetLogEntries() {
+		_, err := data.NormalizeLevel(input.GetLevel())
+		if err != nil {
+			res := &logs.LogsResponse{Result: "failed"}
+			return res, status.Errorf(codes.InvalidArgument, "entry %d: %v", i, err)
+		}
+
+		entries = append(entries, logEntryFromProto(input))
+	}
+
+	// Write the logs
+	count, err := l.Models.LogEntry.InsertMany(entries)
+	if err != nil {
+		res := &logs.LogsResponse{Result: "failed"}
+		return res, err
+	}
+
+	// Return the response
+	res := &logs.LogsResponse{Result: "logged", Count: int64(count)}
+	return res, nil
+}
+
+// StreamLogs receives a stream of log entries and writes them to the database in
+// batches of STREAM_BATCH_SIZE. Once the client closes the stream, it replies with
+// how many entries were written.
+//
+// If an entry is invalid the stream is rejected, but batches that were already
+// written stay written, and the error says how many there were.
+func (l *LogServer) StreamLogs(stream logs.LogService_StreamLogsServer) error {
+
+	var count int
+	batch := make([]data.LogEntry, 0, STREAM_BATCH_SIZE)
+
+	// flush writes whatever is in the batch, and empties it
+	flush := func() error {
+		written, err := l.Models.LogEntry.InsertMany(batch)
+		count += written
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		input := req.GetLogEntry()
+
+		_, err = data.NormalizeLevel(input.GetLevel())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "entry %d: %v (%d written)", count+len(batch), err, count)
+		}
+
+		batch = append(batch, logEntryFromProto(input))
+
+		if len(batch) == STREAM_BATCH_SIZE {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write whatever is left over
+	err := flush()
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&logs.LogsResponse{Result: "logged", Count: int64(count)})
+}
+
+// TailLogs streams new log entries to the client as they are written, until the
+// client goes away. Entries can be filtered by name and by minimum severity level
+func (l *LogServer) TailLogs(req *logs.TailRequest, stream logs.LogService_TailLogsServer) error {
+
+	tail, err := l.Models.LogEntry.Tail(data.TailFilter{
+		Name:     req.GetName(),
+		MinLevel: req.GetMinLevel(),
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer tail.Close()
+
+	// Send headers straight away, so the client knows the tail is open
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entry := <-tail.Entries:
+			err = stream.Send(&logs.TailResponse{
+				Id:        entry.ID,
+				LogEntry:  logEntryToProto(entry),
+				CreatedAt: timestamppb.New(entry.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// logEntryToProto converts a log entry into its protobuf form. Attributes that
+// can't be represented in protobuf are left out rather than failing the entry
+func logEntryToProto(entry data.LogEntry) *logs.Log {
+
+	attributes, err := structpb.NewStruct(entry.Attributes)
+	if err != nil {
+		log.Printf("Dropping attributes of log entry %s: %v", entry.ID, err)
+		attributes = nil
+	}
+
+	return &logs.Log{
+		Name:       entry.Name,
+		Data:       entry.Data,
+		Level:      entry.Level,
+		Source:     entry.Source,
+		TraceId:    entry.TraceID,
+		Attributes: attributes,
+	}
+}
+
+// logEntryFromProto converts a log entry from its protobuf form
+func logEntryFromProto(input *logs.Log) data.LogEntry {
+	return data.LogEntry{
+		Name:       input.GetName(),
+		Data:       input.GetData(),
+		Level:      input.GetLevel(),
+		Source:     input.GetSource(),
+		TraceID:    input.GetTraceId(),
+		Attributes: input.GetAttributes().AsMap(),
+	}
+}
+
 // grpcListen starts the gRPC server and listens on the configured GRPC_PORT (default 50001).
 // It registers the LogServer with the gRPC server and logs a message when the server is
 // started
@@ -48,8 +220,12 @@ func (app *Config) grpcListen() {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
 
-	// Create a gRPC server
-	s := grpc.NewServer()
+	// Create a gRPC server. It must allow the broker's keepalive pings, or it would
+	// close the shared connection for sending too many
+	s := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             GRPC_MIN_PING_INTERVAL,
+		PermitWithoutStream: true,
+	}))
 
 	// Register the LogServer
 	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
@@ -1,14 +1,28 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/httputil"
 	"github.com/BlackSound1/go-microservices/logger/data"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// JSONPayload is a log entry as it arrives over HTTP. Only the name and data are
+// required, and everything else is optional
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Level      string         `json:"level,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 // WriteLog handles writing a log entry to the database.
@@ -16,13 +30,28 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	var requestPayload JSONPayload
 
-	// Read the request JSON
-	_ = app.readJSON(w, r, &requestPayload)
+	// Events forwarded by the listener arrive as CloudEvents, in either mode.
+	// Everything else is plain JSON
+	if events.IsCloudEvent(r.Header) {
+		payload, err := payloadFromEvent(r)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		requestPayload = payload
+	} else {
+		// Read the request JSON
+		_ = app.readJSON(w, r, &requestPayload)
+	}
 
 	// Create a new LogEntry for this payload
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:       requestPayload.Name,
+		Data:       requestPayload.Data,
+		Level:      requestPayload.Level,
+		Source:     requestPayload.Source,
+		TraceID:    requestPayload.TraceID,
+		Attributes: requestPayload.Attributes,
 	}
 
 	// Insert the log entry
@@ -41,3 +70,273 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// Write the response
 	app.writeJSON(w, http.StatusAccepted, response)
 }
+
+// payloadFromEvent reads a log entry from a CloudEvents log event. The entry's source
+// and trace ID default to the event's source and correlation ID
+func payloadFromEvent(r *http.Request) (JSONPayload, error) {
+
+	event, err := events.DecodeRequest(r)
+	if err != nil {
+		return JSONPayload{}, err
+	}
+
+	entry, err := event.LogData()
+	if err != nil {
+		return JSONPayload{}, err
+	}
+
+	if entry.Source == "" {
+		entry.Source = event.Source
+	}
+
+	if entry.TraceID == "" {
+		entry.TraceID = event.CorrelationID
+	}
+
+	return JSONPayload(entry), nil
+}
+
+// LogPage is one page of log entries, along with the cursor for the next page
+type LogPage struct {
+	Logs       []*data.LogEntry `json:"logs"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// ListLogs finds log entries, filtered by the query parameters:
+//
+//   - name: only entries with exactly this name
+//   - level, source, trace_id: only entries with this severity, source or trace ID
+//   - from, to: only entries created in this time range (RFC 3339)
+//   - q: only entries whose data contains this text
+//   - sort: "created_at" (the default) or "name", and order: "desc" (the default) or "asc"
+//   - limit: how many entries to return, and cursor: the next_cursor of a previous page
+func (app *Config) ListLogs(w http.ResponseWriter, r *http.Request) {
+
+	params := r.URL.Query()
+
+	query := data.LogQuery{
+		Name:     params.Get("name"),
+		Level:    params.Get("level"),
+		Source:   params.Get("source"),
+		TraceID:  params.Get("trace_id"),
+		Contains: params.Get("q"),
+		SortBy:   params.Get("sort"),
+		Cursor:   params.Get("cursor"),
+	}
+
+	// Read the time range
+	var err error
+	if from := params.Get("from"); from != "" {
+		query.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			app.errorJSON(w, errors.New("from must be an RFC 3339 time"))
+			return
+		}
+	}
+
+	if to := params.Get("to"); to != "" {
+		query.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			app.errorJSON(w, errors.New("to must be an RFC 3339 time"))
+			return
+		}
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Asc = true
+	default:
+		app.errorJSON(w, errors.New(`order must be "asc" or "desc"`))
+		return
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			app.errorJSON(w, errors.New("limit must be a number"))
+			return
+		}
+	}
+
+	// Find the entries
+	logs, next, err := app.Models.LogEntry.Query(query)
+	if errors.Is(err, data.ErrInvalidQuery) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "logs",
+		Data: LogPage{
+			Logs:       logs,
+			NextCursor: next,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, response)
+}
+
+// StreamLogs streams new log entries to the client as Server-Sent Events, as they
+// are written. It takes two optional query parameters:
+//
+//   - name: only entries with exactly this name
+//   - level: only entries at least this severe
+//
+// Each entry is sent as a "log" event, with the entry's ID as the event ID. If the
+// client falls behind and entries are skipped, a "dropped" event says how many.
+func (app *Config) StreamLogs(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	tail, err := app.Models.LogEntry.Tail(data.TailFilter{
+		Name:     r.URL.Query().Get("name"),
+		MinLevel: r.URL.Query().Get("level"),
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer tail.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comment lines keep proxies from timing out a quiet stream
+	heartbeat := time.NewTicker(httputil.SSE_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	dropped := 0
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			err = httputil.WriteHeartbeat(w)
+		case entry := <-tail.Entries:
+			err = httputil.WriteEvent(w, "log", entry.ID, entry)
+
+			if n := tail.Dropped(); err == nil && n > dropped {
+				err = httputil.WriteEvent(w, "dropped", "", map[string]int{"dropped": n - dropped})
+				dropped = n
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// GetLog gets a single log entry by ID
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+	if !primitive.IsValidObjectID(id) {
+		app.errorJSON(w, errors.New("invalid log entry id"))
+		return
+	}
+
+	entry, err := app.Models.LogEntry.GetOne(id)
+	if err != nil {
+		app.logError(w, err)
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "log entry",
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateLog replaces the contents of a single log entry
+func (app *Config) UpdateLog(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+	if !primitive.IsValidObjectID(id) {
+		app.errorJSON(w, errors.New("invalid log entry id"))
+		return
+	}
+
+	var requestPayload JSONPayload
+
+	// Read the request JSON
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	_, err = data.NormalizeLevel(requestPayload.Level)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Find the entry to update
+	entry, err := app.Models.LogEntry.GetOne(id)
+	if err != nil {
+		app.logError(w, err)
+		return
+	}
+
+	// Update it
+	entry.Name = requestPayload.Name
+	entry.Data = requestPayload.Data
+	entry.Level = requestPayload.Level
+	entry.Source = requestPayload.Source
+	entry.TraceID = requestPayload.TraceID
+	entry.Attributes = requestPayload.Attributes
+
+	_, err = entry.Update()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Read it back, to get the new updated_at
+	entry, err = app.Models.LogEntry.GetOne(entry.ID)
+	if err != nil {
+		app.logError(w, err)
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "updated",
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, response)
+}
+
+// logError sends the right status code for an error from looking up a log entry
+func (app *Config) logError(w http.ResponseWriter, err error) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errors.New("log entry not found"), http.StatusNotFound)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
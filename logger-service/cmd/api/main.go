@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
 	"time"
 
 	"github.com/BlackSound1/go-microservices/logger/data"
@@ -51,8 +52,34 @@ func main() {
 		Models: data.New(client),
 	}
 
+	// Set up how long logs are kept for
+	retention, err := data.ParseRetention(os.Getenv("LOG_RETENTION"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = app.Models.LogEntry.ApplyRetention(retention)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Bring the expiry times of existing entries in line with the policy. This can
+	// take a while, and entries just keep their old expiry times if it fails, so it
+	// doesn't hold up starting
+	go func() {
+		n, err := app.Models.LogEntry.BackfillRetention(retention)
+		if err != nil {
+			log.Println("Error applying retention to existing logs:", err)
+			return
+		}
+
+		if n > 0 {
+			log.Printf("Updated the expiry time of %d existing log entries", n)
+		}
+	}()
+
 	// Register our custom RPC server type
-	err = rpc.Register(new(RPCServer))
+	err = rpc.Register(&RPCServer{Models: app.Models})
 	if err != nil {
 		log.Fatal("error registering RPC server: ", err)
 	}
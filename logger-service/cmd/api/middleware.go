@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+)
+
+// requireAdmin only lets requests through if they carry an access token belonging
+// to an active admin. Tokens are checked by the auth service
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		_, err := httputil.RequireAdmin(r.Context(), httputil.AUTH_VALIDATE_URL, httputil.BearerToken(r))
+		if err != nil {
+			app.errorJSON(w, err, httputil.AuthStatus(err))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -25,6 +25,18 @@ func (app *Config) routes() http.Handler {
 
 	// Set up handlers
 	mux.Post("/log", app.WriteLog)
+	mux.Get("/logs", app.ListLogs)
+	mux.Get("/logs/stream", app.StreamLogs)
+	mux.Get("/logs/{id}", app.GetLog)
+	mux.Put("/logs/{id}", app.UpdateLog)
+
+	// Admin-only maintenance
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/purge", app.PreviewPurge)
+		mux.Post("/purge", app.RunPurge)
+	})
 
 	return mux
 }
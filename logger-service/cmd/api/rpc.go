@@ -1,32 +1,45 @@
 package main
 
 import (
-	"context"
+	"encoding/gob"
 	"log"
-	"time"
 
 	"github.com/BlackSound1/go-microservices/logger/data"
 )
 
-type RPCServer struct{}
+type RPCServer struct {
+	Models data.Models
+}
 
+// RPCPayload is a log entry as it arrives over RPC. Callers that only send
+// Name and Data still work, since gob ignores fields that aren't sent
 type RPCPayload struct {
-	Name string
-	Data string
+	Name       string
+	Data       string
+	Level      string
+	Source     string
+	TraceID    string
+	Attributes map[string]any
+}
+
+func init() {
+	// Attributes can hold nested objects and arrays, which gob needs to know about
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
 }
 
 // LogInfo processes the given RPCPayload by inserting a log entry into the MongoDB
 // database and returns a response message
 func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
 
-	// Create a logs collection in Mongo
-	collection := client.Database("logs").Collection("logs")
-
 	// Insert a log entry
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
+	err := r.Models.LogEntry.Insert(data.LogEntry{
+		Name:       payload.Name,
+		Data:       payload.Data,
+		Level:      payload.Level,
+		Source:     payload.Source,
+		TraceID:    payload.TraceID,
+		Attributes: payload.Attributes,
 	})
 	if err != nil {
 		log.Println("error writing to Mongo", err)
@@ -35,6 +48,6 @@ func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
 
 	// Set the response message
 	*resp = "Processed payload via RPC: " + payload.Name
-	
+
 	return nil
 }
@@ -2,7 +2,13 @@ package data
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,18 +17,44 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	DEFAULT_QUERY_LIMIT = 50
+	MAX_QUERY_LIMIT     = 500
+)
+
+// The severity levels a log entry can have
+const (
+	LEVEL_DEBUG   = "DEBUG"
+	LEVEL_INFO    = "INFO"
+	LEVEL_WARNING = "WARNING"
+	LEVEL_ERROR   = "ERROR"
+)
+
 var client *mongo.Client
 
+// ErrInvalidQuery is wrapped by every error caused by a LogQuery asking for
+// something that can't be done
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidCursor is returned when a query's cursor can't be decoded, or was made
+// for a different sort order
+var ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrInvalidQuery)
+
 type Models struct {
 	LogEntry LogEntry
 }
 
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID         string         `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string         `bson:"name" json:"name"`
+	Data       string         `bson:"data" json:"data"`
+	Level      string         `bson:"level" json:"level"`
+	Source     string         `bson:"source,omitempty" json:"source,omitempty"`
+	TraceID    string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	Attributes map[string]any `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `bson:"updated_at" json:"updated_at"`
+	ExpiresAt  *time.Time     `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
 }
 
 // New creates a Models instance with a given MongoDB client.
@@ -34,28 +66,128 @@ func New(mongo *mongo.Client) Models {
 	}
 }
 
+// NormalizeLevel returns the canonical form of the given severity level. Levels
+// are case-insensitive, "WARN" is short for "WARNING", and no level at all means
+// "INFO", so entries from before levels existed are still accepted.
+func NormalizeLevel(level string) (string, error) {
+
+	level = strings.ToUpper(strings.TrimSpace(level))
+
+	switch level {
+	case "":
+		return LEVEL_INFO, nil
+	case "WARN":
+		return LEVEL_WARNING, nil
+	case LEVEL_DEBUG, LEVEL_INFO, LEVEL_WARNING, LEVEL_ERROR:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown log level %q", level)
+	}
+}
+
 // Insert adds a new log entry to the database.
 func (l *LogEntry) Insert(entry LogEntry) error {
 
+	level, err := NormalizeLevel(entry.Level)
+	if err != nil {
+		return err
+	}
+
 	// Create a database called "logs" and a collection in that database called "logs".
 	// Will just use it if it exists and create it if it doesn't.
 	collection := client.Database("logs").Collection("logs")
 
+	now := time.Now()
+	doc := LogEntry{
+		Name:       entry.Name,
+		Data:       entry.Data,
+		Level:      level,
+		Source:     entry.Source,
+		TraceID:    entry.TraceID,
+		Attributes: entry.Attributes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiresAt:  retention.ExpiresAt(entry.Name, level, now),
+	}
+
 	// Insert a log entry into the collection
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	result, err := collection.InsertOne(context.TODO(), doc)
 	if err != nil {
 		log.Println("Error inserting logs:", err)
 		return err
 	}
 
+	// Let anyone tailing the logs know about it
+	doc.ID = insertedID(result.InsertedID)
+	publish(doc)
+
 	return nil
 }
 
+// InsertMany adds several log entries to the database in one round trip, and returns
+// how many were added. If any entry has an unknown level, none of them are added.
+func (l *LogEntry) InsertMany(entries []LogEntry) (int, error) {
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	// Check every entry before writing any of them
+	now := time.Now()
+	docs := make([]any, 0, len(entries))
+	written := make([]LogEntry, 0, len(entries))
+
+	for i, entry := range entries {
+		level, err := NormalizeLevel(entry.Level)
+		if err != nil {
+			return 0, fmt.Errorf("entry %d: %w", i, err)
+		}
+
+		doc := LogEntry{
+			Name:       entry.Name,
+			Data:       entry.Data,
+			Level:      level,
+			Source:     entry.Source,
+			TraceID:    entry.TraceID,
+			Attributes: entry.Attributes,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			ExpiresAt:  retention.ExpiresAt(entry.Name, level, now),
+		}
+		docs = append(docs, doc)
+		written = append(written, doc)
+	}
+
+	// Create a timeout to prevent long execution
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.InsertMany(ctx, docs)
+	if err != nil {
+		log.Println("Error inserting logs:", err)
+		return 0, err
+	}
+
+	// Let anyone tailing the logs know about them
+	for i, id := range result.InsertedIDs {
+		written[i].ID = insertedID(id)
+	}
+	publish(written...)
+
+	return len(result.InsertedIDs), nil
+}
+
+// insertedID returns the hex form of the ID MongoDB gave a newly inserted document
+func insertedID(id any) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+
+	return ""
+}
+
 // GetAll gets all log entries from the database, sorted by creation date in descending order.
 func (l *LogEntry) GetAll() ([]*LogEntry, error) {
 
@@ -146,7 +278,8 @@ func (l *LogEntry) DropCollection(db, collection string) error {
 	return nil
 }
 
-// Update modifies an existing log entry in the database by its ID.
+// Update modifies an existing log entry in the database by its ID. Its expiry time
+// is worked out again, in case its name or level changed.
 func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 
 	// Create a timeout to prevent long execution
@@ -161,6 +294,11 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 		return nil, err
 	}
 
+	level, err := NormalizeLevel(l.Level)
+	if err != nil {
+		return nil, err
+	}
+
 	// Update this LogEntry
 	result, err := collection.UpdateOne(
 		ctx,
@@ -169,7 +307,12 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 			{Key: "$set", Value: bson.D{
 				{Key: "name", Value: l.Name},
 				{Key: "data", Value: l.Data},
+				{Key: "level", Value: level},
+				{Key: "source", Value: l.Source},
+				{Key: "trace_id", Value: l.TraceID},
+				{Key: "attributes", Value: l.Attributes},
 				{Key: "updated_at", Value: time.Now()},
+				{Key: "expires_at", Value: retention.ExpiresAt(l.Name, level, l.CreatedAt)},
 			}},
 		},
 	)
@@ -179,3 +322,217 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 
 	return result, nil
 }
+
+// LogQuery describes which log entries to find, and in which order. Zero values
+// mean "don't filter on this"
+type LogQuery struct {
+	Name     string    // Only entries with exactly this name
+	Level    string    // Only entries with this severity level
+	Source   string    // Only entries from this source
+	TraceID  string    // Only entries with this trace ID
+	From     time.Time // Only entries created at or after this time
+	To       time.Time // Only entries created before this time
+	Contains string    // Only entries whose data contains this text, ignoring case
+	SortBy   string    // "created_at" (the default) or "name"
+	Asc      bool      // Sort in ascending order rather than descending
+	Limit    int       // How many entries to return, up to MAX_QUERY_LIMIT
+	Cursor   string    // Where to carry on from, as returned by a previous Query
+}
+
+// queryCursor marks the last entry of a page, so the next page can start after it
+type queryCursor struct {
+	SortBy string `json:"s"`
+	Asc    bool   `json:"a"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+// Query finds log entries matching the given LogQuery. It returns one page of entries,
+// along with a cursor for the next page, which is empty if there are no more entries.
+//
+// Pages are found by the position of their last entry rather than by skipping, so
+// entries being added while paging through don't cause any to be repeated or missed.
+func (l *LogEntry) Query(q LogQuery) ([]*LogEntry, string, error) {
+
+	if q.SortBy == "" {
+		q.SortBy = "created_at"
+	}
+
+	if q.SortBy != "created_at" && q.SortBy != "name" {
+		return nil, "", fmt.Errorf("%w: can't sort by %q", ErrInvalidQuery, q.SortBy)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DEFAULT_QUERY_LIMIT
+	} else if q.Limit > MAX_QUERY_LIMIT {
+		q.Limit = MAX_QUERY_LIMIT
+	}
+
+	filter, err := q.filter()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Create a timeout to prevent long execution
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	// Sort by the requested field, using the ID to break ties
+	direction := -1
+	if q.Asc {
+		direction = 1
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: q.SortBy, Value: direction}, {Key: "_id", Value: direction}})
+	opts.SetLimit(int64(q.Limit) + 1) // One extra, to see if there's another page
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println("Querying docs error: ", err)
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []*LogEntry{}
+
+	err = cursor.All(ctx, &logs)
+	if err != nil {
+		log.Println("Error decoding log entries: ", err)
+		return nil, "", err
+	}
+
+	// If there was nothing past this page, there's no next cursor
+	if len(logs) <= q.Limit {
+		return logs, "", nil
+	}
+
+	logs = logs[:q.Limit]
+	next, err := q.encodeCursor(logs[len(logs)-1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return logs, next, nil
+}
+
+// filter builds the MongoDB filter for the entries to find
+func (q LogQuery) filter() (bson.D, error) {
+
+	// Build up the filter
+	filter := bson.D{}
+
+	if q.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: q.Name})
+	}
+
+	if q.Level != "" {
+		level, err := NormalizeLevel(q.Level)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		filter = append(filter, bson.E{Key: "level", Value: level})
+	}
+
+	if q.Source != "" {
+		filter = append(filter, bson.E{Key: "source", Value: q.Source})
+	}
+
+	if q.TraceID != "" {
+		filter = append(filter, bson.E{Key: "trace_id", Value: q.TraceID})
+	}
+
+	if !q.From.IsZero() || !q.To.IsZero() {
+		createdAt := bson.D{}
+		if !q.From.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$gte", Value: q.From})
+		}
+		if !q.To.IsZero() {
+			createdAt = append(createdAt, bson.E{Key: "$lt", Value: q.To})
+		}
+		filter = append(filter, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	if q.Contains != "" {
+		filter = append(filter, bson.E{Key: "data", Value: primitive.Regex{
+			Pattern: regexp.QuoteMeta(q.Contains),
+			Options: "i",
+		}})
+	}
+
+	// Carry on after the last entry of the previous page
+	if q.Cursor != "" {
+		after, err := q.cursorFilter()
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, after...)
+	}
+
+	return filter, nil
+}
+
+// encodeCursor creates a cursor pointing just past the given entry
+func (q LogQuery) encodeCursor(last *LogEntry) (string, error) {
+
+	c := queryCursor{SortBy: q.SortBy, Asc: q.Asc, ID: last.ID}
+
+	switch q.SortBy {
+	case "name":
+		c.Value = last.Name
+	default:
+		c.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cursorFilter decodes the query's cursor into a filter matching every entry that
+// comes after it in the query's sort order
+func (q LogQuery) cursorFilter() (bson.D, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c queryCursor
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.SortBy != q.SortBy || c.Asc != q.Asc {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var value any = c.Value
+	if q.SortBy == "created_at" {
+		value, err = time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	op := "$lt"
+	if q.Asc {
+		op = "$gt"
+	}
+
+	// Either strictly past the value, or on the same value but past the ID
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: q.SortBy, Value: bson.D{{Key: op, Value: value}}}},
+		bson.D{
+			{Key: q.SortBy, Value: value},
+			{Key: "_id", Value: bson.D{{Key: op, Value: id}}},
+		},
+	}}}, nil
+}
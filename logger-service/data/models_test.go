@@ -0,0 +1,22 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Query_Invalid(t *testing.T) {
+	var l LogEntry
+
+	// Invalid queries are refused before the database is asked, so none are mistaken
+	// for the database failing
+	for name, q := range map[string]LogQuery{
+		"sort":   {SortBy: "level"},
+		"level":  {Level: "loud"},
+		"cursor": {Cursor: "not a cursor"},
+	} {
+		if _, _, err := l.Query(q); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: expected ErrInvalidQuery but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RETENTION_BACKFILL_TIMEOUT is how long updating the expiry times of existing log
+// entries may take. It runs in the background, so it can take much longer than queries
+const RETENTION_BACKFILL_TIMEOUT = 10 * time.Minute
+
+// retention is the policy new log entries are given an expiry time by
+var retention RetentionPolicy
+
+// RetentionPolicy says how long log entries are kept for. A rule for an entry's
+// name beats a rule for its level, which beats the default. Zero means "forever"
+type RetentionPolicy struct {
+	Default time.Duration
+	ByName  map[string]time.Duration
+	ByLevel map[string]time.Duration
+}
+
+// ParseRetention reads a RetentionPolicy from a comma-separated list of rules,
+// each of which is one of:
+//
+//   - default=<duration>: how long entries without a more specific rule are kept
+//   - name:<name>=<duration>: how long entries with this name are kept
+//   - level:<level>=<duration>: how long entries with this level are kept
+//
+// Durations are in Go's format, plus "d" for days, e.g. "30d" or "12h". A
+// duration of 0 keeps entries forever.
+func ParseRetention(spec string) (RetentionPolicy, error) {
+
+	policy := RetentionPolicy{
+		ByName:  make(map[string]time.Duration),
+		ByLevel: make(map[string]time.Duration),
+	}
+
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		target, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return RetentionPolicy{}, fmt.Errorf("retention rule %q has no duration", rule)
+		}
+
+		ttl, err := parseRetentionDuration(strings.TrimSpace(value))
+		if err != nil {
+			return RetentionPolicy{}, fmt.Errorf("retention rule %q: %w", rule, err)
+		}
+
+		target = strings.TrimSpace(target)
+		kind, key, _ := strings.Cut(target, ":")
+
+		switch {
+		case target == "default":
+			policy.Default = ttl
+		case kind == "name" && key != "":
+			policy.ByName[key] = ttl
+		case kind == "level":
+			level, err := NormalizeLevel(key)
+			if err != nil || key == "" {
+				return RetentionPolicy{}, fmt.Errorf("retention rule %q has an unknown level", rule)
+			}
+			policy.ByLevel[level] = ttl
+		default:
+			return RetentionPolicy{}, fmt.Errorf("retention rule %q should start with default, name: or level:", rule)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseRetentionDuration parses a duration, allowing whole days as "<n>d"
+func parseRetentionDuration(value string) (time.Duration, error) {
+
+	var ttl time.Duration
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		_, err := fmt.Sscanf(days, "%d", &n)
+		if err != nil || fmt.Sprint(n) != days {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if ttl < 0 {
+		return 0, errors.New("duration can't be negative")
+	}
+
+	return ttl, nil
+}
+
+// TTL returns how long an entry with the given name and level is kept for, or
+// zero if it is kept forever
+func (p RetentionPolicy) TTL(name, level string) time.Duration {
+
+	if ttl, ok := p.ByName[name]; ok {
+		return ttl
+	}
+
+	if ttl, ok := p.ByLevel[level]; ok {
+		return ttl
+	}
+
+	return p.Default
+}
+
+// ExpiresAt returns when an entry with the given name and level, created at the
+// given time, should be deleted, or nil if it should be kept forever
+func (p RetentionPolicy) ExpiresAt(name, level string, createdAt time.Time) *time.Time {
+
+	ttl := p.TTL(name, level)
+	if ttl == 0 {
+		return nil
+	}
+
+	expiresAt := createdAt.Add(ttl)
+	return &expiresAt
+}
+
+// ApplyRetention makes the given policy the one log entries are given expiry times
+// by, and makes sure the TTL index that deletes expired entries exists. Entries
+// already in the database keep their expiry times until BackfillRetention updates them.
+func (l *LogEntry) ApplyRetention(policy RetentionPolicy) error {
+
+	retention = policy
+
+	// Create a timeout to prevent long execution
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	// Documents are deleted as soon as their expires_at has passed. Entries without
+	// one are never deleted
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Println("Error creating TTL index:", err)
+		return err
+	}
+
+	return nil
+}
+
+// BackfillRetention recomputes the expiry times of the log entries already in the
+// database from when they were created, so a changed policy applies to them too. Only
+// entries whose expiry time changes are written, but every entry might need to be
+// looked at, so it can take a while on a big collection. It returns how many entries
+// were updated
+func (l *LogEntry) BackfillRetention(policy RetentionPolicy) (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), RETENTION_BACKFILL_TIMEOUT)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.BulkWrite(ctx, policy.updates(), options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// updates returns the updates that give every existing entry the expiry time the
+// policy gives it. Each rule's entries are updated together, leaving out any that a
+// more specific rule covers, and entries that already have the right expiry time
+func (p RetentionPolicy) updates() []mongo.WriteModel {
+
+	names := make([]string, 0, len(p.ByName))
+	for name := range p.ByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	levels := make([]string, 0, len(p.ByLevel))
+	for level := range p.ByLevel {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+
+	var models []mongo.WriteModel
+
+	for _, name := range names {
+		models = append(models, expiryUpdate(bson.D{{Key: "name", Value: name}}, p.ByName[name]))
+	}
+
+	for _, level := range levels {
+		models = append(models, expiryUpdate(bson.D{
+			{Key: "level", Value: level},
+			{Key: "name", Value: bson.D{{Key: "$nin", Value: names}}},
+		}, p.ByLevel[level]))
+	}
+
+	models = append(models, expiryUpdate(bson.D{
+		{Key: "level", Value: bson.D{{Key: "$nin", Value: levels}}},
+		{Key: "name", Value: bson.D{{Key: "$nin", Value: names}}},
+	}, p.Default))
+
+	return models
+}
+
+// expiryUpdate sets the expiry time of the entries matching the given filter to ttl
+// after they were created, or removes it if ttl is zero
+func expiryUpdate(filter bson.D, ttl time.Duration) mongo.WriteModel {
+
+	if ttl == 0 {
+		filter = append(filter, bson.E{Key: "expires_at", Value: bson.D{{Key: "$exists", Value: true}}})
+
+		return mongo.NewUpdateManyModel().
+			SetFilter(filter).
+			SetUpdate(bson.D{{Key: "$unset", Value: bson.D{{Key: "expires_at", Value: ""}}}})
+	}
+
+	// Adding milliseconds to a date gives a date
+	expiresAt := bson.D{{Key: "$add", Value: bson.A{"$created_at", ttl.Milliseconds()}}}
+
+	filter = append(filter, bson.E{Key: "$expr", Value: bson.D{{Key: "$ne", Value: bson.A{"$expires_at", expiresAt}}}})
+
+	return mongo.NewUpdateManyModel().
+		SetFilter(filter).
+		SetUpdate(mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: expiresAt}}}}})
+}
+
+// PurgeFilter describes which log entries to purge. Only Before is required
+type PurgeFilter struct {
+	Before time.Time // Only entries created before this time
+	Name   string    // Only entries with exactly this name
+	Level  string    // Only entries with this severity level
+}
+
+// filter builds the MongoDB filter for the entries to purge
+func (f PurgeFilter) filter() (bson.D, error) {
+
+	if f.Before.IsZero() {
+		return nil, fmt.Errorf("%w: a cutoff time is required", ErrInvalidQuery)
+	}
+
+	filter := bson.D{{Key: "created_at", Value: bson.D{{Key: "$lt", Value: f.Before}}}}
+
+	if f.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: f.Name})
+	}
+
+	if f.Level != "" {
+		level, err := NormalizeLevel(f.Level)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		filter = append(filter, bson.E{Key: "level", Value: level})
+	}
+
+	return filter, nil
+}
+
+// CountPurgeable returns how many log entries Purge would delete with the given filter
+func (l *LogEntry) CountPurgeable(f PurgeFilter) (int64, error) {
+
+	filter, err := f.filter()
+	if err != nil {
+		return 0, err
+	}
+
+	// Create a timeout to prevent long execution
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	return collection.CountDocuments(ctx, filter)
+}
+
+// Purge deletes the log entries matching the given filter, and returns how many
+// were deleted
+func (l *LogEntry) Purge(f PurgeFilter) (int64, error) {
+
+	filter, err := f.filter()
+	if err != nil {
+		return 0, err
+	}
+
+	// Purges can be big, so allow them longer than other queries
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Println("Error purging logs:", err)
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
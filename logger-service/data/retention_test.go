@@ -0,0 +1,102 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_ParseRetention(t *testing.T) {
+	policy, err := ParseRetention("default=30d, level:debug=24h, name:auth=90d, level:WARN=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		level string
+		want  time.Duration
+	}{
+		{"event", LEVEL_INFO, 30 * 24 * time.Hour},
+		{"event", LEVEL_DEBUG, 24 * time.Hour},
+		{"auth", LEVEL_DEBUG, 90 * 24 * time.Hour},
+		{"event", LEVEL_WARNING, 0},
+	}
+
+	for _, tt := range tests {
+		if got := policy.TTL(tt.name, tt.level); got != tt.want {
+			t.Errorf("%s/%s: expected %v but got %v", tt.name, tt.level, tt.want, got)
+		}
+	}
+
+	if policy.ExpiresAt("event", LEVEL_WARNING, time.Now()) != nil {
+		t.Error("expected entries with a zero TTL to never expire")
+	}
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := policy.ExpiresAt("event", LEVEL_DEBUG, created); got == nil || !got.Equal(created.Add(24*time.Hour)) {
+		t.Errorf("unexpected expiry time %v", got)
+	}
+}
+
+func Test_ParseRetention_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"forever",
+		"default=soon",
+		"default=-1h",
+		"default=1.5d",
+		"level:loud=1h",
+		"name:=1h",
+		"source:auth=1h",
+	} {
+		if _, err := ParseRetention(spec); err == nil {
+			t.Errorf("expected an error parsing %q", spec)
+		}
+	}
+
+	policy, err := ParseRetention("")
+	if err != nil || policy.TTL("event", LEVEL_INFO) != 0 {
+		t.Errorf("expected an empty spec to keep everything forever, got %+v, %v", policy, err)
+	}
+}
+
+func Test_RetentionPolicy_Updates(t *testing.T) {
+	policy, err := ParseRetention("default=30d, level:debug=24h, name:auth=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := policy.updates()
+	if len(models) != 3 {
+		t.Fatalf("expected an update for each rule and the default but got %d", len(models))
+	}
+
+	// Entries kept forever lose their expiry time
+	auth := models[0].(*mongo.UpdateManyModel)
+	if filter := auth.Filter.(bson.D); filter[0].Value != "auth" || filter[1].Key != "expires_at" {
+		t.Errorf("expected the auth rule to match auth entries with an expiry time but got %v", filter)
+	}
+
+	if update, ok := auth.Update.(bson.D); !ok || update[0].Key != "$unset" {
+		t.Errorf("expected the auth rule to remove expiry times but got %v", auth.Update)
+	}
+
+	// Entries a name rule covers are left out of the level rules and the default
+	debug := models[1].(*mongo.UpdateManyModel)
+	filter := debug.Filter.(bson.D)
+	if filter[0].Value != LEVEL_DEBUG || filter[1].Value.(bson.D)[0].Value.([]string)[0] != "auth" {
+		t.Errorf("expected the debug rule to leave out auth entries but got %v", filter)
+	}
+
+	update := debug.Update.(mongo.Pipeline)[0][0].Value.(bson.D)[0].Value.(bson.D)[0].Value.(bson.A)
+	if update[1] != (24 * time.Hour).Milliseconds() {
+		t.Errorf("expected debug entries to expire a day after they were created but got %v", update)
+	}
+
+	defaults := models[2].(*mongo.UpdateManyModel).Filter.(bson.D)
+	if defaults[0].Value.(bson.D)[0].Value.([]string)[0] != LEVEL_DEBUG {
+		t.Errorf("expected the default to leave out debug entries but got %v", defaults)
+	}
+}
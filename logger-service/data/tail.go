@@ -0,0 +1,126 @@
+package data
+
+import "sync"
+
+// TAIL_BUFFER is how many new entries a tail can fall behind by before it
+// starts missing them
+const TAIL_BUFFER = 256
+
+// levelRanks orders the severity levels, from least to most severe
+var levelRanks = map[string]int{
+	LEVEL_DEBUG:   0,
+	LEVEL_INFO:    1,
+	LEVEL_WARNING: 2,
+	LEVEL_ERROR:   3,
+}
+
+// feed fans out every newly written log entry to the open tails. Change streams
+// would need MongoDB to run as a replica set, so entries are published by this
+// process as it writes them instead.
+var feed = struct {
+	sync.Mutex
+	tails map[*Tail]struct{}
+}{
+	tails: make(map[*Tail]struct{}),
+}
+
+// TailFilter describes which new log entries a tail wants. Zero values mean
+// "don't filter on this"
+type TailFilter struct {
+	Name     string // Only entries with exactly this name
+	MinLevel string // Only entries at least this severe
+}
+
+// Tail receives log entries as they are written.
+//
+// A tail that doesn't keep up doesn't hold up writes: once its buffer is full,
+// new entries are dropped for it, and Dropped says how many.
+type Tail struct {
+	Entries <-chan LogEntry
+
+	entries  chan LogEntry
+	name     string
+	minLevel int
+	dropped  int
+	closed   bool
+}
+
+// Tail starts receiving new log entries that match the given filter. It must be
+// closed once it is no longer needed
+func (l *LogEntry) Tail(filter TailFilter) (*Tail, error) {
+
+	t := Tail{
+		entries: make(chan LogEntry, TAIL_BUFFER),
+		name:    filter.Name,
+	}
+	t.Entries = t.entries
+
+	if filter.MinLevel != "" {
+		level, err := NormalizeLevel(filter.MinLevel)
+		if err != nil {
+			return nil, err
+		}
+		t.minLevel = levelRanks[level]
+	}
+
+	feed.Lock()
+	feed.tails[&t] = struct{}{}
+	feed.Unlock()
+
+	return &t, nil
+}
+
+// Close stops the tail and closes its Entries channel
+func (t *Tail) Close() {
+
+	feed.Lock()
+	defer feed.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	delete(feed.tails, t)
+	t.closed = true
+	close(t.entries)
+}
+
+// Dropped returns how many matching entries the tail has missed because it
+// wasn't keeping up
+func (t *Tail) Dropped() int {
+
+	feed.Lock()
+	defer feed.Unlock()
+
+	return t.dropped
+}
+
+// matches reports whether the tail wants the given entry
+func (t *Tail) matches(entry LogEntry) bool {
+	if t.name != "" && entry.Name != t.name {
+		return false
+	}
+
+	return levelRanks[entry.Level] >= t.minLevel
+}
+
+// publish sends newly written entries to every tail that wants them
+func publish(entries ...LogEntry) {
+
+	feed.Lock()
+	defer feed.Unlock()
+
+	for t := range feed.tails {
+		for _, entry := range entries {
+			if !t.matches(entry) {
+				continue
+			}
+
+			select {
+			case t.entries <- entry:
+			default:
+				t.dropped++
+			}
+		}
+	}
+}
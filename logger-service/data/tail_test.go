@@ -0,0 +1,52 @@
+package data
+
+import "testing"
+
+func Test_Tail(t *testing.T) {
+	var l LogEntry
+
+	tail, err := l.Tail(TailFilter{Name: "event", MinLevel: "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+
+	publish(
+		LogEntry{Name: "event", Level: LEVEL_INFO},
+		LogEntry{Name: "other", Level: LEVEL_ERROR},
+		LogEntry{Name: "event", Level: LEVEL_ERROR, Data: "wanted"},
+	)
+
+	if len(tail.Entries) != 1 {
+		t.Fatalf("expected 1 matching entry but got %d", len(tail.Entries))
+	}
+
+	if entry := <-tail.Entries; entry.Data != "wanted" {
+		t.Errorf("got the wrong entry: %+v", entry)
+	}
+
+	// Entries past the buffer are dropped rather than blocking
+	for range TAIL_BUFFER + 5 {
+		publish(LogEntry{Name: "event", Level: LEVEL_ERROR})
+	}
+
+	if tail.Dropped() != 5 {
+		t.Errorf("expected 5 dropped entries but got %d", tail.Dropped())
+	}
+
+	tail.Close()
+	tail.Close()
+
+	if _, ok := <-tail.Entries; !ok {
+		t.Fatal("expected buffered entries to still be readable after closing")
+	}
+}
+
+func Test_Tail_InvalidLevel(t *testing.T) {
+	var l LogEntry
+
+	_, err := l.Tail(TailFilter{MinLevel: "loud"})
+	if err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
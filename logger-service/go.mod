@@ -3,6 +3,8 @@ module github.com/BlackSound1/go-microservices/logger
 go 1.23.1
 
 require (
+	github.com/BlackSound1/go-microservices/events v0.0.0
+	github.com/BlackSound1/go-microservices/httputil v0.0.0
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-chi/cors v1.2.1
 	go.mongodb.org/mongo-driver v1.17.1
@@ -14,6 +16,7 @@ require (
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/montanaflynn/stats v0.7.1 // indirect
+	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
@@ -25,3 +28,7 @@ require (
 	golang.org/x/text v0.19.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
 )
+
+replace github.com/BlackSound1/go-microservices/events => ../events
+
+replace github.com/BlackSound1/go-microservices/httputil => ../httputil
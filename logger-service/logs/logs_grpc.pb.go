@@ -23,6 +23,9 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LogServiceClient interface {
 	WriteLog(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogResponse, error)
+	WriteLogs(ctx context.Context, in *LogsRequest, opts ...grpc.CallOption) (*LogsResponse, error)
+	StreamLogs(ctx context.Context, opts ...grpc.CallOption) (LogService_StreamLogsClient, error)
+	TailLogs(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (LogService_TailLogsClient, error)
 }
 
 type logServiceClient struct {
@@ -42,11 +45,89 @@ func (c *logServiceClient) WriteLog(ctx context.Context, in *LogRequest, opts ..
 	return out, nil
 }
 
+func (c *logServiceClient) WriteLogs(ctx context.Context, in *LogsRequest, opts ...grpc.CallOption) (*LogsResponse, error) {
+	out := new(LogsResponse)
+	err := c.cc.Invoke(ctx, "/logs.LogService/WriteLogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logServiceClient) StreamLogs(ctx context.Context, opts ...grpc.CallOption) (LogService_StreamLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], "/logs.LogService/StreamLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServiceStreamLogsClient{stream}
+	return x, nil
+}
+
+type LogService_StreamLogsClient interface {
+	Send(*LogRequest) error
+	CloseAndRecv() (*LogsResponse, error)
+	grpc.ClientStream
+}
+
+type logServiceStreamLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServiceStreamLogsClient) Send(m *LogRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *logServiceStreamLogsClient) CloseAndRecv() (*LogsResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(LogsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *logServiceClient) TailLogs(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (LogService_TailLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[1], "/logs.LogService/TailLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServiceTailLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LogService_TailLogsClient interface {
+	Recv() (*TailResponse, error)
+	grpc.ClientStream
+}
+
+type logServiceTailLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServiceTailLogsClient) Recv() (*TailResponse, error) {
+	m := new(TailResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // LogServiceServer is the server API for LogService service.
 // All implementations must embed UnimplementedLogServiceServer
 // for forward compatibility
 type LogServiceServer interface {
 	WriteLog(context.Context, *LogRequest) (*LogResponse, error)
+	WriteLogs(context.Context, *LogsRequest) (*LogsResponse, error)
+	StreamLogs(LogService_StreamLogsServer) error
+	TailLogs(*TailRequest, LogService_TailLogsServer) error
 	mustEmbedUnimplementedLogServiceServer()
 }
 
@@ -57,6 +138,15 @@ type UnimplementedLogServiceServer struct {
 func (UnimplementedLogServiceServer) WriteLog(context.Context, *LogRequest) (*LogResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteLog not implemented")
 }
+func (UnimplementedLogServiceServer) WriteLogs(context.Context, *LogsRequest) (*LogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteLogs not implemented")
+}
+func (UnimplementedLogServiceServer) StreamLogs(LogService_StreamLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedLogServiceServer) TailLogs(*TailRequest, LogService_TailLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method TailLogs not implemented")
+}
 func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
 
 // UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +178,71 @@ func _LogService_WriteLog_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LogService_WriteLogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).WriteLogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/logs.LogService/WriteLogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).WriteLogs(ctx, req.(*LogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LogService_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).StreamLogs(&logServiceStreamLogsServer{stream})
+}
+
+type LogService_StreamLogsServer interface {
+	SendAndClose(*LogsResponse) error
+	Recv() (*LogRequest, error)
+	grpc.ServerStream
+}
+
+type logServiceStreamLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServiceStreamLogsServer) SendAndClose(m *LogsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *logServiceStreamLogsServer) Recv() (*LogRequest, error) {
+	m := new(LogRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _LogService_TailLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServiceServer).TailLogs(m, &logServiceTailLogsServer{stream})
+}
+
+type LogService_TailLogsServer interface {
+	Send(*TailResponse) error
+	grpc.ServerStream
+}
+
+type logServiceTailLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServiceTailLogsServer) Send(m *TailResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,7 +254,22 @@ var LogService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "WriteLog",
 			Handler:    _LogService_WriteLog_Handler,
 		},
+		{
+			MethodName: "WriteLogs",
+			Handler:    _LogService_WriteLogs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _LogService_StreamLogs_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "TailLogs",
+			Handler:       _LogService_TailLogs_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "logs.proto",
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+const (
+	MAIL_FORM_FIELD        = "mail"        // The multipart form field holding the mail's JSON
+	ATTACHMENT_FORM_FIELD  = "attachments" // The multipart form field files are attached with
+	INLINE_FORM_FIELD      = "inline"      // The multipart form field inline images are attached with
+	MULTIPART_MEMORY_LIMIT = 8 << 20       // How much of a multipart form is kept in memory before going to disk
+)
+
+// readMail reads a mail from a request, as either JSON, with its attachments base64
+// encoded, or a multipart/form-data upload.
+//
+// An upload has the mail's JSON in the "mail" field, and its files in the
+// "attachments" and "inline" fields. Each file's MIME type is taken from its part's
+// Content-Type. Inline files get their file name as their content ID, so the HTML
+// can show them with <img src="cid:logo.png">
+func (app *Config) readMail(w http.ResponseWriter, r *http.Request, m *events.MailData) error {
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return app.readJSON(w, r, m, events.MAX_MAIL_REQUEST_SIZE)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, events.MAX_MAIL_REQUEST_SIZE)
+
+	err := r.ParseMultipartForm(MULTIPART_MEMORY_LIMIT)
+	if err != nil {
+		return err
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	err = json.Unmarshal([]byte(r.FormValue(MAIL_FORM_FIELD)), m)
+	if err != nil {
+		return fmt.Errorf("the %q field must hold the mail as JSON: %w", MAIL_FORM_FIELD, err)
+	}
+
+	for _, field := range []string{ATTACHMENT_FORM_FIELD, INLINE_FORM_FIELD} {
+		for _, header := range r.MultipartForm.File[field] {
+			attachment, err := readAttachment(header)
+			if err != nil {
+				return err
+			}
+
+			if field == INLINE_FORM_FIELD {
+				attachment.ContentID = header.Filename
+			}
+
+			m.Attachments = append(m.Attachments, attachment)
+		}
+	}
+
+	return nil
+}
+
+// readAttachment reads an uploaded file into an attachment
+func readAttachment(header *multipart.FileHeader) (events.Attachment, error) {
+
+	if header.Filename == "" {
+		return events.Attachment{}, errors.New("every attachment needs a file name")
+	}
+
+	if header.Size > events.MAX_ATTACHMENT_SIZE {
+		return events.Attachment{}, fmt.Errorf("attachment %q is bigger than %d bytes", header.Filename, events.MAX_ATTACHMENT_SIZE)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return events.Attachment{}, err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return events.Attachment{}, err
+	}
+
+	// Browsers send files they don't know as application/octet-stream, so leave those
+	// for the mail library to guess from the file name
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "application/octet-stream" {
+		contentType = ""
+	}
+
+	return events.Attachment{
+		Filename:    header.Filename,
+		ContentType: contentType,
+		Content:     content,
+	}, nil
+}
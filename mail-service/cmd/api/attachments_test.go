@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/BlackSound1/go-microservices/events"
+)
+
+func Test_readMail_Multipart(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	_ = form.WriteField(MAIL_FORM_FIELD, `{"to":"you@example.com","subject":"Files"}`)
+
+	part, _ := form.CreateFormFile(ATTACHMENT_FORM_FIELD, "report.pdf")
+	_, _ = part.Write([]byte("%PDF"))
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="inline"; filename="logo.png"`)
+	header.Set("Content-Type", "image/png")
+	part, _ = form.CreatePart(header)
+	_, _ = part.Write([]byte("PNG"))
+
+	_ = form.Close()
+
+	r := httptest.NewRequest("POST", "/send", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	var app Config
+	var m events.MailData
+
+	err := app.readMail(httptest.NewRecorder(), r, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "Files" || len(m.Attachments) != 2 {
+		t.Fatalf("unexpected mail %+v", m)
+	}
+
+	// Unknown types are left for the mail library to guess from the file name
+	report := m.Attachments[0]
+	if report.Filename != "report.pdf" || report.ContentType != "" || report.ContentID != "" || string(report.Content) != "%PDF" {
+		t.Errorf("unexpected attachment %+v", report)
+	}
+
+	logo := m.Attachments[1]
+	if logo.ContentType != "image/png" || logo.ContentID != "logo.png" {
+		t.Errorf("unexpected inline attachment %+v", logo)
+	}
+
+	if err := m.Validate(); err != nil {
+		t.Errorf("expected a valid mail but got %v", err)
+	}
+}
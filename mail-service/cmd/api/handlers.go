@@ -1,50 +1,141 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/mail/deliveries"
 )
 
+// messageFromData converts a mail, as it arrives over HTTP or in a job, into a Message
+func messageFromData(m events.MailData) Message {
+	return Message{
+		From:            m.From,
+		To:              m.To,
+		CC:              m.CC,
+		BCC:             m.BCC,
+		ReplyTo:         m.ReplyTo,
+		Headers:         m.Headers,
+		Subject:         m.Subject,
+		Data:            m.Message,
+		DataMap:         m.Data,
+		Template:        m.Template,
+		TemplateVersion: m.TemplateVersion,
+		Attachments:     m.Attachments,
+	}
+}
+
 // SendMail handles sending an email by reading the request payload,
 // constructing a Message object, and sending it via the Mailer.
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 
-	// mailMessage struct holds the email data received in the request
-	type mailMessage struct {
-		From    string `json:"from"`
-		To      string `json:"to"`
-		Subject string `json:"subject"`
-		Message string `json:"message"`
+	// Read the mail, as JSON or a multipart form with the files attached to it. To,
+	// CC and BCC can each be a single address or a list
+	var requestPayload events.MailData
+	err := app.readMail(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
 
-	// Read the JSON request body into requestPayload
-	var requestPayload mailMessage
-	err := app.readJSON(w, r, &requestPayload)
+	// Make sure every address and header is valid, reporting each one that isn't
+	err = requestPayload.Validate()
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
 	// Create a Message object from the request payload
-	msg := Message{
-		From:    requestPayload.From,
-		To:      requestPayload.To,
-		Subject: requestPayload.Subject,
-		Data:    requestPayload.Message,
+	msg := messageFromData(requestPayload)
+
+	// Give it an ID, and a record of how its delivery goes
+	delivery, err := app.trackMessage("", msg)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
+	app.updateMessage(delivery.ID, deliveries.SENDING, 1, "", nil)
+
 	// Send the email using the Mailer
-	err = app.Mailer.SendSMTPMessage(msg)
+	response, err := app.Mailer.SendMessage(msg)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.updateMessage(delivery.ID, deliveries.FAILED, 1, response, err)
+
+		// The mail itself is only to blame if it couldn't be built
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, ErrDelivery):
+			status = http.StatusBadGateway
+		case errors.Is(err, ErrTemplateNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrSuppressed):
+			status = http.StatusUnprocessableEntity
+		}
+
+		// Still say which message it was, since its record has the details
+		app.writeJSON(w, status, JSONResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    map[string]string{"message_id": delivery.ID, "status": string(deliveries.FAILED)},
+		})
 		return
 	}
 
-	// Set up a response payload
+	app.updateMessage(delivery.ID, deliveries.SENT, 1, response, nil)
+
+	// Set up a response payload, with the ID the message's status can be looked up by
 	payload := JSONResponse{
 		Error:   false,
-		Message: "sent mail to " + requestPayload.To,
+		Message: "sent mail to " + strings.Join(requestPayload.Recipients(), ", "),
+		Data:    map[string]string{"message_id": delivery.ID, "status": string(deliveries.SENT)},
 	}
 
 	// Send a success JSON response
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
+
+// RenderMail renders an email exactly as it would be sent, CSS inlining included,
+// and returns its subject, HTML and plain text without sending anything
+func (app *Config) RenderMail(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload events.MailData
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	content, err := app.Mailer.Render(messageFromData(requestPayload))
+	if errors.Is(err, ErrTemplateNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "rendered " + content.Template,
+		Data:    content,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ListTemplates lists every mail template, along with its versions
+func (app *Config) ListTemplates(w http.ResponseWriter, r *http.Request) {
+
+	templates := app.Mailer.Templates.List()
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "templates",
+		Data:    templates,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"net/http"
+
+	"github.com/BlackSound1/go-microservices/events"
 )
 
 type JSONResponse struct {
@@ -15,11 +17,16 @@ type JSONResponse struct {
 
 // readJSON reads a JSON from a request body into the target data.
 //
-// It checks that the body is not more than 1 MB and that it only contains 1 JSON value.
-func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
+// It checks that the body is not more than 1 MB, or the given size if there is one,
+// and that it only contains 1 JSON value.
+func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any, size ...int64) error {
 
-	// Make sure JSON file is less than 1 MB. Shouldn't be more than that
+	// Make sure JSON file is less than 1 MB, unless the caller expects more, like a
+	// mail with attachments
 	maxBytes := int64(1048576) // 1 MB
+	if len(size) > 0 {
+		maxBytes = size[0]
+	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 
 	// Create decoder for JSON
@@ -90,6 +97,12 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	payload.Error = true
 	payload.Message = err.Error()
 
+	// Report every invalid field, like a mail's addresses, one by one
+	var fieldErrors events.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		payload.Data = map[string]any{"errors": fieldErrors}
+	}
+
 	// Send the response
 	return app.writeJSON(w, statusCode, payload)
 }
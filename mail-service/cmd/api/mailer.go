@@ -1,14 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"html/template"
-	"time"
-
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"path/filepath"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/mail/suppressions"
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
+// ErrDelivery is wrapped by errors from the transport failing to deliver a mail that
+// was built fine, as opposed to the mail itself being wrong
+var ErrDelivery = errors.New("mail could not be delivered")
+
 // Defines a struct for the Mailer object itself
 type Mail struct {
 	Domain      string
@@ -19,22 +27,48 @@ type Mail struct {
 	Encryption  string
 	FromAddress string
 	FromName    string
+	Templates   *TemplateRegistry
+	Transport   Transport // Delivers mail once it's built, over SMTP unless MAIL_TRANSPORT says otherwise
+
+	// Mail is never sent to suppressed addresses. Single recipient mail gets a link,
+	// signed with UnsubscribeSecret, to UnsubscribeURL, where they can unsubscribe
+	Suppressions      *suppressions.List
+	UnsubscribeURL    string
+	UnsubscribeSecret []byte
 }
 
-// Defines a single email
+// Defines a single email. It is rendered from the named template, or DEFAULT_TEMPLATE,
+// with DataMap as the template's data. Data is available to the template as "message"
 type Message struct {
-	From        string
-	FromName    string
-	To          string
-	Subject     string
-	Attachments []string
-	Data        any
-	DataMap     map[string]any
+	From            string
+	FromName        string
+	To              []string
+	CC              []string
+	BCC             []string
+	ReplyTo         string
+	Headers         map[string]string // Extra headers, like List-Unsubscribe
+	Subject         string
+	Attachments     []events.Attachment
+	Data            any
+	DataMap         map[string]any
+	Template        string
+	TemplateVersion int    // The newest version of the template is used if this is 0
+	UnsubscribeURL  string // Available to the template as "unsubscribe_url"
 }
 
-// SendSMTPMessage sends an email using a provided Message struct and the SMTP
-// server connection details specified in the Mailer struct.
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// SendMessage builds an email from a provided Message struct and sends it with the
+// Mailer's transport. It returns the mail server's reply, if the transport has one.
+// Suppressed recipients are left out, and if that leaves nobody, it returns ErrSuppressed.
+// Errors from the transport itself wrap ErrDelivery.
+func (m *Mail) SendMessage(msg Message) (string, error) {
+
+	// Leave out anyone who has bounced or opted out
+	msg, err := m.suppress(msg)
+	if err != nil {
+		return "", err
+	}
+
+	msg = m.addUnsubscribe(msg)
 
 	// If From is not specified, use the default From address for the Mailer
 	if msg.From == "" {
@@ -46,63 +80,90 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
-	// Create a DataMap based on the messages data
-	data := map[string]any{
-		"message": msg.Data,
+	// Render the email from its template
+	content, err := m.Render(msg)
+	if err != nil {
+		return "", err
 	}
-	msg.DataMap = data
 
-	// Create an HTML-formatted version of the email
-	formattedMessage, err := m.buildHTMLMessage(msg)
-	if err != nil {
-		return err
+	// Set up the email message
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).
+		AddTo(msg.To...).
+		AddCc(msg.CC...).
+		AddBcc(msg.BCC...).
+		SetSubject(content.Subject)
+
+	if msg.ReplyTo != "" {
+		email.SetReplyTo(msg.ReplyTo)
 	}
 
-	// Create a plain-formatted version of the email
-	plainText, err := m.buildPlainTextMessage(msg)
-	if err != nil {
-		return err
+	for name, value := range msg.Headers {
+		email.AddHeader(name, value)
 	}
 
-	// Set up the SMTP client
-	server := mail.NewSMTPClient()
-	server.Host = m.Host
-	server.Port = m.Port
-	server.Username = m.Username
-	server.Password = m.Password
-	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
+	// Templates may only have one format
+	switch {
+	case content.Plain != "" && content.HTML != "":
+		email.SetBody(mail.TextPlain, content.Plain)      // Default body is plain message
+		email.AddAlternative(mail.TextHTML, content.HTML) // Alternative body is HTML message
+	case content.HTML != "":
+		email.SetBody(mail.TextHTML, content.HTML)
+	default:
+		email.SetBody(mail.TextPlain, content.Plain)
+	}
+
+	// If there are any attachments, add them to the message
+	for _, attachment := range msg.Attachments {
+		email.Attach(attachmentFile(attachment))
+	}
 
-	// Connect to the SMTP server
-	smtpClient, err := server.Connect()
+	// Send the email with whichever transport is configured
+	response, err := m.Transport.Send(email)
 	if err != nil {
-		return err
+		return response, fmt.Errorf("%w: %w", ErrDelivery, err)
 	}
 
-	// Set up the email message
-	email := mail.NewMSG()
-	email.SetFrom(msg.From).
-		AddTo(msg.To).
-		SetSubject(msg.Subject).
-		SetBody(mail.TextPlain, plainText).             // Default body is plain message
-		AddAlternative(mail.TextHTML, formattedMessage) // Alternative body is HTML message
+	return response, nil
+}
 
-	// If there are any attachments, add them to the message
-	if len(msg.Attachments) > 0 {
-		for _, attachment := range msg.Attachments {
-			email.AddAttachment(attachment)
-		}
+// attachmentFile converts an attachment into a file for the mail library. Inline files
+// are named after their content ID, since that's how the library matches them up with
+// the cid: references in the HTML
+func attachmentFile(a events.Attachment) *mail.File {
+
+	file := &mail.File{
+		Name:     a.Filename,
+		MimeType: a.ContentType,
+		Data:     a.Content,
 	}
 
-	// Send the email
-	err = email.Send(smtpClient)
-	if err != nil {
-		return err
+	// The content ID may not have an extension to guess the type from
+	if file.MimeType == "" {
+		file.MimeType = mime.TypeByExtension(filepath.Ext(a.Filename))
+	}
+
+	if a.ContentID != "" {
+		file.Name = a.ContentID
+		file.Inline = true
 	}
 
-	return nil
+	return file
+}
+
+// smtpServer returns the SMTP server details mail is sent through
+func (m *Mail) smtpServer() *mail.SMTPServer {
+
+	server := mail.NewSMTPClient()
+	server.Host = m.Host
+	server.Port = m.Port
+	server.Username = m.Username
+	server.Password = m.Password
+	server.Encryption = m.getEncryption(m.Encryption)
+	server.ConnectTimeout = SMTP_CONNECT_TIMEOUT
+	server.SendTimeout = SMTP_SEND_TIMEOUT
+
+	return server
 }
 
 // getEncryption returns the appropriate mail.Encryption type based on the input string.
@@ -120,56 +181,60 @@ func (m *Mail) getEncryption(s string) mail.Encryption {
 	}
 }
 
-// buildPlainTextMessage builds a plain text email message from a template and a Message object.
-// It parses the template, executes it with the Message data, and then returns the formatted plain text string.
-func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
+// Render renders an email exactly as it would be sent, without sending it. Templates
+// can supply a subject for messages that don't have one
+func (m *Mail) Render(msg Message) (Rendered, error) {
 
-	// Create an email template based on the HTML file
-	templateToRender := "./templates/mail.plain.gohtml"
-	t, err := template.New("email-plain").ParseFiles(templateToRender)
+	content, err := m.buildMessage(msg)
 	if err != nil {
-		return "", err
+		return Rendered{}, err
 	}
 
-	// Try to execute the template, checking for errors in template file
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
-		return "", err
+	if msg.Subject != "" {
+		content.Subject = msg.Subject
 	}
 
-	// Convert the template to a string
-	plainMessage := tpl.String()
-
-	return plainMessage, nil
+	return content, nil
 }
 
-// buildHTMLMessage builds an HTML email message from a template and a Message object.
-// It parses the template, executes it with the Message data, and then inlines the CSS.
-func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
+// buildMessage renders an email from its template, with the message's data map plus
+// its data as "message". The CSS in the HTML version is then inlined
+func (m *Mail) buildMessage(msg Message) (Rendered, error) {
+
+	name := msg.Template
+	if name == "" {
+		name = DEFAULT_TEMPLATE
+	}
 
-	// Create an email template based on the HTML file
-	templateToRender := "./templates/mail.html.gohtml"
-	t, err := template.New("email-html").ParseFiles(templateToRender)
+	t, err := m.Templates.Get(name, msg.TemplateVersion)
 	if err != nil {
-		return "", err
+		return Rendered{}, err
 	}
 
-	// Try to execute the template, checking for errors in template file
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
-		return "", err
+	// Give the template the message's data as well as its data map
+	data := make(map[string]any, len(msg.DataMap)+1)
+	maps.Copy(data, msg.DataMap)
+	if _, ok := data["message"]; !ok {
+		data["message"] = msg.Data
+	}
+	if msg.UnsubscribeURL != "" {
+		data["unsubscribe_url"] = msg.UnsubscribeURL
 	}
 
-	// Convert the template to a string
-	formattedMessage := tpl.String()
+	content, err := t.Render(data)
+	if err != nil {
+		return Rendered{}, err
+	}
 
 	// Inline the CSS
-	formattedMessage, err = m.inlineCSS(formattedMessage)
-	if err != nil {
-		return "", err
+	if content.HTML != "" {
+		content.HTML, err = m.inlineCSS(content.HTML)
+		if err != nil {
+			return Rendered{}, err
+		}
 	}
 
-	return formattedMessage, nil
+	return content, nil
 }
 
 // inlineCSS takes an HTML string, inlines the CSS, and returns the new HTML string.
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/mail/deliveries"
+	"github.com/go-chi/chi/v5"
+)
+
+// PRUNE_INTERVAL is how often old delivery records are looked for
+const PRUNE_INTERVAL = time.Hour
+
+// GetMessage reports how the delivery of a message is going, by the ID it was given
+// when it was sent or queued
+func (app *Config) GetMessage(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	delivery, err := app.Deliveries.Get(id)
+	if errors.Is(err, deliveries.ErrNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "message " + string(delivery.Status),
+		Data:    delivery,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// pruneMessages deletes the records of messages that finished longer ago than
+// retention, every PRUNE_INTERVAL, until the given context is cancelled
+func (app *Config) pruneMessages(ctx context.Context, retention time.Duration) {
+
+	ticker := time.NewTicker(PRUNE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.Deliveries.Prune(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("Error pruning delivery records:", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d delivery records", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackMessage starts the delivery record of a message, as queued. It's given a new
+// ID unless it already has one, like a job does. If the message is already being
+// tracked, its record is returned as it is
+func (app *Config) trackMessage(id string, msg Message) (deliveries.Delivery, error) {
+
+	recipients := make([]string, 0, len(msg.To)+len(msg.CC)+len(msg.BCC))
+	recipients = append(recipients, msg.To...)
+	recipients = append(recipients, msg.CC...)
+	recipients = append(recipients, msg.BCC...)
+
+	return app.Deliveries.Create(deliveries.Delivery{
+		ID:         id,
+		Status:     deliveries.QUEUED,
+		Recipients: recipients,
+		Subject:    msg.Subject,
+		Template:   msg.Template,
+	})
+}
+
+// updateMessage records the status of a message, how many times it has been tried,
+// and how the last try went. A failure to record it is only logged, since it
+// shouldn't stop the message being sent
+func (app *Config) updateMessage(id string, status deliveries.Status, attempts int, response string, sendErr error) {
+
+	_, err := app.Deliveries.Update(id, func(d *deliveries.Delivery) {
+		d.Status = status
+		d.Attempts = attempts
+		d.Response = response
+
+		if sendErr != nil {
+			d.LastError = sendErr.Error()
+		}
+
+		if status == deliveries.SENT {
+			now := time.Now().UTC()
+			d.SentAt = &now
+		}
+	})
+	if err != nil {
+		log.Printf("Error recording delivery of message %s: %v", id, err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+)
+
+// requireAdminKey only lets requests through if they carry the admin key, which the
+// broker sends once it has checked the caller is an admin. If there's no admin key,
+// nothing gets through
+func (app *Config) requireAdminKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get(httputil.ADMIN_KEY_HEADER)
+		if app.AdminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(app.AdminKey)) != 1 {
+			app.errorJSON(w, errors.New("a valid admin key is required"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/mail/deliveries"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	MAIL_QUEUE             = "mail"             // Holds mail jobs until they are sent
+	MAIL_RETRY_QUEUE       = "mail.retry"       // Prefix of the queues failed jobs wait in, one per retry, like "mail.retry.1"
+	MAIL_DEAD_LETTER       = "mail_dead_letter" // Where jobs that can't be sent are dead-lettered
+	MAIL_DEAD_LETTER_QUEUE = "mail.dead"        // Holds jobs that can't be sent, for someone to look at
+	MAIL_WORKERS           = 4                  // How many jobs are sent at once
+	MAX_SEND_ATTEMPTS      = 6                  // How many times to try sending a job before giving up on it
+	RETRY_MIN_BACKOFF      = 2 * time.Second    // How long to wait before the first retry
+	RETRY_MAX_BACKOFF      = time.Minute        // The longest to ever wait between retries
+	ATTEMPTS_HEADER        = "x-attempts"       // How many times a job has been tried
+	PUBLISH_TIMEOUT        = 5 * time.Second    // How long to wait for a retry to be published
+	STATUS_TIMEOUT         = 5 * time.Second    // How long to wait for a job's status to be published
+)
+
+// ErrInvalidJob is returned for jobs that can never be sent, however many times
+// they are retried
+var ErrInvalidJob = errors.New("invalid mail job")
+
+// Publisher publishes an event with the given routing key. events.Emitter is one
+type Publisher interface {
+	Push(ctx context.Context, event events.Envelope, routingKey string) error
+}
+
+// jobChannel is the part of an AMQP channel retries are published with
+type jobChannel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// ConsumeMail sends the mail jobs published to the mail queue. It must be called
+// before the Connection is started, so the queue is declared and consumed on every
+// connection.
+//
+// Jobs are only acknowledged once they are sent, or handed back to RabbitMQ to be
+// retried. Failed jobs wait in a retry queue, for longer after each attempt, then go
+// back on the mail queue. A job is dead-lettered once it has been tried
+// MAX_SEND_ATTEMPTS times. Either way, the job's final status is published as a log
+// event, with the job ID as its trace ID
+func (app *Config) ConsumeMail(ctx context.Context) {
+	app.Rabbit.OnConnect(func(conn *amqp.Connection) error {
+		return app.consumeMail(ctx, conn)
+	})
+}
+
+// consumeMail declares the mail queue on a new connection, and starts sending jobs
+// from it in the background, until the connection is lost
+func (app *Config) consumeMail(ctx context.Context, conn *amqp.Connection) error {
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = declareMailQueue(ch)
+	if err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	// Only take as many jobs as there are workers to send them
+	err = ch.Qos(MAIL_WORKERS, 0, false)
+	if err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	jobs, err := ch.Consume(MAIL_QUEUE, "", false, false, false, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	log.Println("Waiting for mail jobs on queue", MAIL_QUEUE)
+
+	var wg sync.WaitGroup
+	for range MAIL_WORKERS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for d := range jobs {
+				app.handleJob(ctx, ch, d)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		_ = ch.Close()
+	}()
+
+	return nil
+}
+
+// declareMailQueue declares the exchange mail jobs are published to, the durable
+// mail queue bound to it, a retry queue for each retry, and the dead letter queue for
+// jobs that can't be sent.
+//
+// Each retry queue holds jobs for as long as that retry waits, then dead-letters them
+// straight back onto the mail queue. Since every job in a retry queue waits the same
+// time, none of them hold up the others
+func declareMailQueue(ch *amqp.Channel) error {
+
+	err := events.DeclareExchange(ch)
+	if err != nil {
+		return err
+	}
+
+	err = ch.ExchangeDeclare(MAIL_DEAD_LETTER, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(MAIL_DEAD_LETTER_QUEUE, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(MAIL_DEAD_LETTER_QUEUE, "", MAIL_DEAD_LETTER, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt < MAX_SEND_ATTEMPTS; attempt++ {
+		_, err = ch.QueueDeclare(retryQueue(attempt), true, false, false, false, amqp.Table{
+			"x-message-ttl":             retryBackoff(attempt - 1).Milliseconds(),
+			"x-dead-letter-exchange":    "", // The default exchange, which routes by queue name
+			"x-dead-letter-routing-key": MAIL_QUEUE,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = ch.QueueDeclare(MAIL_QUEUE, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": MAIL_DEAD_LETTER,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(MAIL_QUEUE, events.MAIL_ROUTING_KEY, events.LOGS_EXCHANGE, false, nil)
+}
+
+// handleJob tries to send a single mail job, then acknowledges it, schedules it to be
+// retried, or dead-letters it
+func (app *Config) handleJob(ctx context.Context, ch jobChannel, d amqp.Delivery) {
+
+	event, err := events.DecodeDelivery(d)
+	if err != nil {
+		log.Printf("Dead-lettering malformed mail job %q: %v", d.MessageId, err)
+		_ = d.Nack(false, false)
+		return
+	}
+
+	attempt := attemptCount(d) + 1
+
+	attempts, response, err := app.sendJob(event, attempt)
+
+	switch {
+	case err == nil:
+		_ = d.Ack(false)
+		app.updateMessage(event.ID, deliveries.SENT, attempts, response, nil)
+	case errors.Is(err, ErrInvalidJob) || attempts >= MAX_SEND_ATTEMPTS:
+		log.Printf("Dead-lettering mail job %s after %d attempts: %v", event.ID, attempts, err)
+		_ = d.Nack(false, false)
+		app.updateMessage(event.ID, deliveries.FAILED, attempts, response, err)
+	default:
+		log.Printf("Error sending mail job %s, retrying in %s: %v", event.ID, retryBackoff(attempts-1), err)
+
+		retryErr := scheduleRetry(ctx, ch, d, attempts)
+		if retryErr != nil {
+			// Put it back on the queue rather than lose it
+			log.Println("Error scheduling retry:", retryErr)
+			_ = d.Nack(false, true)
+		} else {
+			_ = d.Ack(false)
+		}
+
+		app.updateMessage(event.ID, deliveries.QUEUED, attempts, response, err)
+		return
+	}
+
+	app.recordStatus(event, attempts, err)
+}
+
+// sendJob makes the given attempt at sending the mail in a job, and keeps its delivery
+// record up to date. The job ID is the message ID. It returns how many attempts the
+// job has taken, and the mail server's reply
+func (app *Config) sendJob(event events.Envelope, attempt int) (int, string, error) {
+
+	data, err := event.MailData()
+	if err == nil {
+		err = data.Validate()
+	}
+
+	msg := messageFromData(data)
+
+	// A job that's redelivered after it was sent, say because the service stopped
+	// before acknowledging it, isn't sent again
+	delivery, trackErr := app.trackMessage(event.ID, msg)
+	if trackErr != nil {
+		log.Printf("Error tracking mail job %s: %v", event.ID, trackErr)
+	} else if delivery.Status == deliveries.SENT {
+		return delivery.Attempts, delivery.Response, nil
+	}
+
+	if err != nil {
+		return attempt, "", fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	}
+
+	app.updateMessage(event.ID, deliveries.SENDING, attempt, "", nil)
+
+	response, err := app.Mailer.SendMessage(msg)
+
+	// The template won't appear, nor the recipients be unsuppressed, by retrying
+	if errors.Is(err, ErrTemplateNotFound) || errors.Is(err, ErrSuppressed) {
+		err = fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	}
+
+	return attempt, response, err
+}
+
+// scheduleRetry publishes a copy of the given job to the retry queue for the
+// attempt after the given one, recording how many attempts it has had
+func scheduleRetry(ctx context.Context, ch jobChannel, d amqp.Delivery, attempts int) error {
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[ATTEMPTS_HEADER] = int32(attempts)
+
+	ctx, cancel := context.WithTimeout(ctx, PUBLISH_TIMEOUT)
+	defer cancel()
+
+	return ch.PublishWithContext(
+		ctx,
+		"",                   // The default exchange, which routes by queue name
+		retryQueue(attempts), // Routing key
+		false,                // Mandatory?
+		false,                // Immediate?
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		},
+	)
+}
+
+// retryQueue returns the name of the queue jobs wait in after the given attempt
+func retryQueue(attempt int) string {
+	return fmt.Sprintf("%s.%d", MAIL_RETRY_QUEUE, attempt)
+}
+
+// attemptCount returns how many times the given job has already been tried
+func attemptCount(d amqp.Delivery) int {
+	switch n := d.Headers[ATTEMPTS_HEADER].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// recordStatus publishes the final status of a job as a log event. A failure to
+// publish it is logged, but doesn't affect the job
+func (app *Config) recordStatus(job events.Envelope, attempts int, sendErr error) {
+
+	entry := events.LogData{
+		Name:    "mail.sent",
+		Data:    fmt.Sprintf("mail job %s sent after %d attempt(s)", job.ID, attempts),
+		Level:   "INFO",
+		Source:  SOURCE,
+		TraceID: job.ID,
+		Attributes: map[string]any{
+			"job_id":   job.ID,
+			"status":   "sent",
+			"attempts": attempts,
+		},
+	}
+
+	if sendErr != nil {
+		entry.Name = "mail.failed"
+		entry.Data = fmt.Sprintf("mail job %s failed after %d attempt(s): %v", job.ID, attempts, sendErr)
+		entry.Level = "ERROR"
+		entry.Attributes["status"] = "failed"
+		entry.Attributes["error"] = sendErr.Error()
+	}
+
+	event, err := events.NewLogEvent(SOURCE, entry, events.WithCorrelationID(job.ID))
+	if err != nil {
+		log.Println("Error recording mail job status:", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), STATUS_TIMEOUT)
+	defer cancel()
+
+	err = app.Emitter.Push(ctx, event, events.LogRoutingKey(entry.Level))
+	if err != nil {
+		log.Printf("Error recording status of mail job %s: %v", job.ID, err)
+	}
+}
+
+// retryBackoff returns how long to wait before retrying a job that has already been
+// retried the given number of times. It doubles each time, up to RETRY_MAX_BACKOFF
+func retryBackoff(retries int) time.Duration {
+	if retries >= 16 {
+		return RETRY_MAX_BACKOFF
+	}
+	return min(RETRY_MIN_BACKOFF<<retries, RETRY_MAX_BACKOFF)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/mail/deliveries"
+	amqp "github.com/rabbitmq/amqp091-go"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// fakeAcknowledger records how a delivery was settled
+type fakeAcknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked, a.requeued = true, requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// fakeChannel records the retries published to it
+type fakeChannel struct {
+	keys       []string
+	publishing []amqp.Publishing
+}
+
+func (c *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.keys = append(c.keys, key)
+	c.publishing = append(c.publishing, msg)
+	return nil
+}
+
+// fakePublisher records the status events published for jobs
+type fakePublisher struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (p *fakePublisher) Push(ctx context.Context, event events.Envelope, routingKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = append(p.keys, routingKey)
+	return nil
+}
+
+// failingTransport fails to send every mail
+type failingTransport struct{}
+
+func (failingTransport) Send(email *mail.Email) (string, error) {
+	return "451 try again later", errors.New("451 try again later")
+}
+
+func (failingTransport) Close() error {
+	return nil
+}
+
+// testJob returns a delivery carrying a mail job, which has already been tried the
+// given number of times
+func testJob(t *testing.T, attempts int) (amqp.Delivery, events.Envelope, *fakeAcknowledger) {
+	event, err := events.NewMailEvent("broker-service", events.MailData{To: events.Addresses{"you@example.com"}, Subject: "Hi", Message: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := event.Publishing(events.MODE_STRUCTURED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts > 0 {
+		msg.Headers = amqp.Table{ATTEMPTS_HEADER: int32(attempts)}
+	}
+
+	ack := &fakeAcknowledger{}
+
+	return amqp.Delivery{Acknowledger: ack, Headers: msg.Headers, ContentType: msg.ContentType, Body: msg.Body}, event, ack
+}
+
+// testQueueApp returns a Config that sends mail with the given transport
+func testQueueApp(t *testing.T, transport Transport) (*Config, *fakePublisher) {
+	m, _ := testMailer(t)
+	m.Transport = transport
+
+	store, err := deliveries.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &fakePublisher{}
+
+	return &Config{Mailer: m, Emitter: publisher, Deliveries: store}, publisher
+}
+
+func Test_HandleJob_Sent(t *testing.T) {
+	app, publisher := testQueueApp(t, NewMemoryTransport())
+	d, event, ack := testJob(t, 0)
+
+	app.handleJob(context.Background(), &fakeChannel{}, d)
+
+	if !ack.acked || ack.nacked {
+		t.Fatalf("expected the job to be acked but got %+v", ack)
+	}
+
+	delivery, _ := app.Deliveries.Get(event.ID)
+	if delivery.Status != deliveries.SENT || delivery.Attempts != 1 {
+		t.Errorf("expected the delivery to be sent in one attempt but got %+v", delivery)
+	}
+
+	if len(publisher.keys) != 1 || publisher.keys[0] != "log.INFO" {
+		t.Errorf("expected the job's status to be published but got %v", publisher.keys)
+	}
+
+	// A redelivered job isn't sent again
+	ack = &fakeAcknowledger{}
+	d.Acknowledger = ack
+	transport := app.Mailer.Transport.(*MemoryTransport)
+	transport.Reset()
+
+	app.handleJob(context.Background(), &fakeChannel{}, d)
+
+	if !ack.acked || len(transport.Sent()) != 0 {
+		t.Errorf("expected the redelivered job to be acked without sending but got %+v, %d sent", ack, len(transport.Sent()))
+	}
+}
+
+func Test_HandleJob_Retry(t *testing.T) {
+	app, publisher := testQueueApp(t, failingTransport{})
+	d, event, ack := testJob(t, 1)
+	ch := &fakeChannel{}
+
+	app.handleJob(context.Background(), ch, d)
+
+	// The job waits in a retry queue, rather than holding up a worker
+	if !ack.acked || ack.nacked {
+		t.Fatalf("expected the job to be acked once its retry was published but got %+v", ack)
+	}
+
+	if len(ch.keys) != 1 || ch.keys[0] != "mail.retry.2" || attemptCount(amqp.Delivery{Headers: ch.publishing[0].Headers}) != 2 {
+		t.Fatalf("expected a retry after the second attempt but got %v", ch.keys)
+	}
+
+	delivery, _ := app.Deliveries.Get(event.ID)
+	if delivery.Status != deliveries.QUEUED || delivery.Attempts != 2 || delivery.LastError == "" {
+		t.Errorf("expected the delivery to be queued again but got %+v", delivery)
+	}
+
+	if len(publisher.keys) != 0 {
+		t.Errorf("expected no status until the job is finished but got %v", publisher.keys)
+	}
+}
+
+func Test_HandleJob_Failed(t *testing.T) {
+	app, publisher := testQueueApp(t, failingTransport{})
+	d, event, ack := testJob(t, MAX_SEND_ATTEMPTS-1)
+	ch := &fakeChannel{}
+
+	app.handleJob(context.Background(), ch, d)
+
+	if !ack.nacked || ack.requeued || len(ch.keys) != 0 {
+		t.Fatalf("expected the job to be dead-lettered but got %+v, retries %v", ack, ch.keys)
+	}
+
+	delivery, _ := app.Deliveries.Get(event.ID)
+	if delivery.Status != deliveries.FAILED || delivery.Attempts != MAX_SEND_ATTEMPTS {
+		t.Errorf("expected the delivery to have failed but got %+v", delivery)
+	}
+
+	if len(publisher.keys) != 1 || publisher.keys[0] != "log.ERROR" {
+		t.Errorf("expected the job's failure to be published but got %v", publisher.keys)
+	}
+
+	// Malformed jobs are dead-lettered straight away
+	ack = &fakeAcknowledger{}
+	app.handleJob(context.Background(), ch, amqp.Delivery{Acknowledger: ack, Body: []byte("{oops")})
+
+	if !ack.nacked || ack.requeued {
+		t.Errorf("expected the malformed job to be dead-lettered but got %+v", ack)
+	}
+}
+
+func Test_RetryBackoff(t *testing.T) {
+	for retries, want := range map[int]string{0: "2s", 1: "4s", 4: "32s", 5: "1m0s", 40: "1m0s"} {
+		if got := retryBackoff(retries).String(); got != want {
+			t.Errorf("%d: expected %s but got %s", retries, want, got)
+		}
+	}
+}
@@ -24,6 +24,22 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping")) // Health check
 
 	mux.Post("/send", app.SendMail)
+	mux.Post("/render", app.RenderMail)
+	mux.Get("/templates", app.ListTemplates)
+	mux.Get("/messages/{id}", app.GetMessage)
+
+	// Recipients follow the links in their mail here, through the broker
+	mux.Get("/unsubscribe", app.Unsubscribe)
+	mux.Post("/unsubscribe", app.Unsubscribe)
+
+	// Only the broker has the key for these, and it only lets admins through
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.requireAdminKey)
+
+		mux.Get("/suppressions", app.ListSuppressions)
+		mux.Post("/suppressions", app.AddSuppression)
+		mux.Delete("/suppressions/{address}", app.RemoveSuppression)
+	})
 
 	return mux
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+const (
+	DEFAULT_SMTP_POOL_SIZE = 4                // How many SMTP connections are kept, unless MAIL_POOL_SIZE says otherwise
+	SMTP_IDLE_TIMEOUT      = 30 * time.Second // Connections idle for longer than this are closed rather than reused
+	SMTP_CONNECT_TIMEOUT   = 10 * time.Second // How long to wait to connect to the SMTP server
+	SMTP_SEND_TIMEOUT      = 10 * time.Second // How long to wait for a message to be sent
+)
+
+// ErrPoolClosed is returned when sending through a pool that has been closed
+var ErrPoolClosed = errors.New("smtp pool is closed")
+
+// SMTPPool holds a few authenticated, keep-alive connections to an SMTP server, so
+// bulk sends reuse sessions instead of connecting for every message. No more messages
+// are sent at once than the pool has connections.
+//
+// The server resets each session (RSET) after every message. Every connection is
+// checked with a NOOP before it's reused, and replaced if it's dead. One that has been
+// idle longer than SMTP_IDLE_TIMEOUT is replaced without asking. A connection that
+// fails to send is thrown away, since its session is in an unknown state
+type SMTPPool struct {
+	server *mail.SMTPServer
+	slots  chan struct{} // Caps how many messages are sent at once
+	mu     sync.Mutex
+	idle   []*pooledClient // Connected, reset and ready for a message
+	closed bool
+}
+
+// pooledClient is an SMTP connection, along with when it was last used
+type pooledClient struct {
+	client   *mail.SMTPClient
+	lastUsed time.Time
+}
+
+// NewSMTPPool creates a pool of up to size connections to the given server. Nothing
+// is connected until the first message is sent
+func NewSMTPPool(server *mail.SMTPServer, size int) *SMTPPool {
+
+	if size < 1 {
+		size = DEFAULT_SMTP_POOL_SIZE
+	}
+
+	// The pool resets sessions between messages, rather than closing them
+	server.KeepAlive = true
+
+	return &SMTPPool{
+		server: server,
+		slots:  make(chan struct{}, size),
+	}
+}
+
+// Send sends an email over one of the pool's connections, waiting for one to be free
+// if they're all busy. It returns the server's reply if the message was refused
+func (p *SMTPPool) Send(email *mail.Email) (string, error) {
+
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	pc, err := p.get()
+	if err != nil {
+		return smtpReply(err), err
+	}
+
+	err = email.Send(pc.client)
+	if err != nil {
+		discard(pc)
+		return smtpReply(err), err
+	}
+
+	p.put(pc)
+
+	// The mail library doesn't pass on what the server said when it accepted the
+	// message, so there's no reply to return
+	return "", nil
+}
+
+// Close closes every idle connection. Connections that are sending are closed once
+// they're done
+func (p *SMTPPool) Close() error {
+
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		discard(pc)
+	}
+
+	return nil
+}
+
+// get returns an idle connection that is still alive, or connects a new one
+func (p *SMTPPool) get() (*pooledClient, error) {
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+
+		// Take the most recently used connection, since it's the likeliest to be alive
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if time.Since(pc.lastUsed) > SMTP_IDLE_TIMEOUT || pc.client.Noop() != nil {
+			discard(pc)
+			continue
+		}
+
+		return pc, nil
+	}
+
+	client, err := p.server.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledClient{client: client}, nil
+}
+
+// put returns a connection to the pool once it has sent a message
+func (p *SMTPPool) put(pc *pooledClient) {
+
+	pc.lastUsed = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		discard(pc)
+		return
+	}
+
+	p.idle = append(p.idle, pc)
+}
+
+// smtpReply returns the code and text of the server's reply, if err is one
+func smtpReply(err error) string {
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return fmt.Sprintf("%d %s", reply.Code, reply.Msg)
+	}
+
+	return ""
+}
+
+// discard closes a connection in the background, since a send that timed out may
+// still be holding it
+func discard(pc *pooledClient) {
+	go func() {
+		_ = pc.client.Quit()
+		_ = pc.client.Close()
+	}()
+}
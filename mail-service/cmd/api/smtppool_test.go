@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// fakeSMTP is just enough of an SMTP server to accept messages. It counts the
+// connections and messages it gets
+type fakeSMTP struct {
+	listener    net.Listener
+	connections atomic.Int32
+	messages    atomic.Int32
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	s := &fakeSMTP{listener: l}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.connections.Add(1)
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ready")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(strings.Fields(line + " ")[0]) {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250 localhost")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			if _, err := tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.messages.Add(1)
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func Test_SMTPPool_ReusesConnections(t *testing.T) {
+	s := newFakeSMTP(t)
+
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+
+	server := mail.NewSMTPClient()
+	server.Host = host
+	server.Port, _ = strconv.Atoi(port)
+	server.Encryption = mail.EncryptionNone
+
+	pool := NewSMTPPool(server, 1)
+	defer pool.Close()
+
+	for i := range 3 {
+		email := mail.NewMSG()
+		email.SetFrom("me@example.com").AddTo("you@example.com").SetSubject("Hi " + strconv.Itoa(i))
+		email.SetBody(mail.TextPlain, "Hello")
+
+		reply, err := pool.Send(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply != "" {
+			t.Errorf("expected no reply to be made up but got %q", reply)
+		}
+	}
+
+	if n := s.messages.Load(); n != 3 {
+		t.Errorf("expected 3 messages but got %d", n)
+	}
+
+	if n := s.connections.Load(); n != 1 {
+		t.Errorf("expected 1 connection to be reused but got %d", n)
+	}
+
+	pool.Close()
+
+	if _, err := pool.Send(mail.NewMSG()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed but got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+const (
+	DEFAULT_TEMPLATE_DIR = "./templates" // Where templates are loaded from, unless MAIL_TEMPLATE_DIR says otherwise
+	DEFAULT_TEMPLATE     = "mail"        // The template used when a message doesn't name one
+)
+
+// ErrTemplateNotFound is returned when a message names a template, or a version of
+// one, that doesn't exist
+var ErrTemplateNotFound = errors.New("mail template not found")
+
+// templateFile matches the names of template files, like "welcome.html.gohtml" or
+// "welcome.v2.plain.gohtml". Files without a version are version 1
+var templateFile = regexp.MustCompile(`^([a-z0-9][a-z0-9_-]*)(?:\.v([0-9]+))?\.(html|plain)\.gohtml$`)
+
+// MailTemplate is a single version of a named template. Every template defines a
+// "body" block, and may define a "subject" block, used when a message has no subject
+type MailTemplate struct {
+	Name    string
+	Version int
+	html    *htmltemplate.Template
+	plain   *texttemplate.Template
+}
+
+// TemplateInfo is what GET /templates reports about each template
+type TemplateInfo struct {
+	Name     string   `json:"name"`
+	Versions []int    `json:"versions"`
+	Latest   int      `json:"latest"`
+	Formats  []string `json:"formats"`
+}
+
+// Rendered is a message's content, rendered from a template
+type Rendered struct {
+	Template string `json:"template"`
+	Version  int    `json:"version"`
+	Subject  string `json:"subject"`
+	HTML     string `json:"html"`
+	Plain    string `json:"plain"`
+}
+
+// TemplateRegistry holds every template in a directory, keyed by name and version.
+// Templates are parsed once, when the registry is loaded, unless reload is set, in
+// which case they're parsed again every time one is used, so they can be edited
+// without restarting the service. While a template fails to parse, the ones loaded
+// before it broke are used
+type TemplateRegistry struct {
+	dir       string
+	reload    bool
+	mu        sync.RWMutex
+	templates map[string]map[int]*MailTemplate
+}
+
+// NewTemplateRegistry loads the templates in the given directory
+func NewTemplateRegistry(dir string, reload bool) (*TemplateRegistry, error) {
+
+	reg := &TemplateRegistry{dir: dir, reload: reload}
+
+	err := reg.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// Load parses every template in the registry's directory, replacing the ones it
+// already has. If any template fails to parse, the old ones are kept
+func (reg *TemplateRegistry) Load() error {
+
+	entries, err := os.ReadDir(reg.dir)
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]map[int]*MailTemplate)
+
+	for _, entry := range entries {
+		match := templateFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		name, format := match[1], match[3]
+
+		version := 1
+		if match[2] != "" {
+			version, _ = strconv.Atoi(match[2])
+		}
+
+		if templates[name] == nil {
+			templates[name] = make(map[int]*MailTemplate)
+		}
+
+		t := templates[name][version]
+		if t == nil {
+			t = &MailTemplate{Name: name, Version: version}
+			templates[name][version] = t
+		}
+
+		path := filepath.Join(reg.dir, entry.Name())
+
+		if format == "html" {
+			t.html, err = htmltemplate.ParseFiles(path)
+		} else {
+			t.plain, err = texttemplate.ParseFiles(path)
+		}
+		if err != nil {
+			return fmt.Errorf("parsing template %s: %w", entry.Name(), err)
+		}
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.templates = templates
+
+	return nil
+}
+
+// Get returns the given version of a template, or its newest version if version is 0
+func (reg *TemplateRegistry) Get(name string, version int) (*MailTemplate, error) {
+
+	reg.refresh()
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	versions, ok := reg.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrTemplateNotFound, name)
+	}
+
+	if version == 0 {
+		for v := range versions {
+			version = max(version, v)
+		}
+	}
+
+	t, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q version %d", ErrTemplateNotFound, name, version)
+	}
+
+	return t, nil
+}
+
+// List returns a description of every template, sorted by name
+func (reg *TemplateRegistry) List() []TemplateInfo {
+
+	reg.refresh()
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	infos := make([]TemplateInfo, 0, len(reg.templates))
+
+	for name, versions := range reg.templates {
+		info := TemplateInfo{Name: name}
+
+		for v, t := range versions {
+			info.Versions = append(info.Versions, v)
+			if v > info.Latest {
+				info.Latest = v
+				info.Formats = t.formats()
+			}
+		}
+
+		sort.Ints(info.Versions)
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// refresh reloads the templates if the registry is set to. A template that fails to
+// parse is only logged, so messages keep being sent with the templates already loaded
+func (reg *TemplateRegistry) refresh() {
+
+	if !reg.reload {
+		return
+	}
+
+	err := reg.Load()
+	if err != nil {
+		log.Println("Error reloading mail templates:", err)
+	}
+}
+
+// Render renders the template with the given data. A template may have only an HTML
+// or only a plain text version, in which case the other is left empty
+func (t *MailTemplate) Render(data map[string]any) (Rendered, error) {
+
+	r := Rendered{Template: t.Name, Version: t.Version}
+	var buf bytes.Buffer
+
+	if t.plain != nil {
+		err := t.plain.ExecuteTemplate(&buf, "body", data)
+		if err != nil {
+			return Rendered{}, err
+		}
+		r.Plain = buf.String()
+
+		if t.plain.Lookup("subject") != nil {
+			buf.Reset()
+			err = t.plain.ExecuteTemplate(&buf, "subject", data)
+			if err != nil {
+				return Rendered{}, err
+			}
+			r.Subject = strings.TrimSpace(buf.String())
+		}
+	}
+
+	if t.html != nil {
+		buf.Reset()
+		err := t.html.ExecuteTemplate(&buf, "body", data)
+		if err != nil {
+			return Rendered{}, err
+		}
+		r.HTML = buf.String()
+
+		// The subject is plain text, so it shouldn't stay escaped
+		if r.Subject == "" && t.html.Lookup("subject") != nil {
+			buf.Reset()
+			err = t.html.ExecuteTemplate(&buf, "subject", data)
+			if err != nil {
+				return Rendered{}, err
+			}
+			r.Subject = html.UnescapeString(strings.TrimSpace(buf.String()))
+		}
+	}
+
+	return r, nil
+}
+
+// formats returns which formats the template has
+func (t *MailTemplate) formats() []string {
+
+	var formats []string
+
+	if t.html != nil {
+		formats = append(formats, "html")
+	}
+
+	if t.plain != nil {
+		formats = append(formats, "plain")
+	}
+
+	return formats
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_TemplateRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"welcome.html.gohtml":     `{{ define "subject" }}Hi {{ .name }}{{ end }}{{ define "body" }}<p>{{ .name }}</p>{{ end }}`,
+		"welcome.plain.gohtml":    `{{ define "body" }}Hi {{ .name }}{{ end }}`,
+		"welcome.v2.plain.gohtml": `{{ define "body" }}Hello {{ .name }}{{ end }}`,
+		"README.md":               `not a template`,
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg, err := NewTemplateRegistry(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The newest version is used unless one is asked for
+	latest, err := reg.Get("welcome", 0)
+	if err != nil || latest.Version != 2 {
+		t.Fatalf("expected version 2 but got %+v (%v)", latest, err)
+	}
+
+	first, err := reg.Get("welcome", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := first.Render(map[string]any{"name": "<Bob>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content.Subject != "Hi <Bob>" || !strings.Contains(content.HTML, "&lt;Bob&gt;") || content.Plain != "Hi <Bob>" {
+		t.Errorf("unexpected content %+v", content)
+	}
+
+	if _, err := reg.Get("welcome", 3); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("expected ErrTemplateNotFound but got %v", err)
+	}
+
+	infos := reg.List()
+	if len(infos) != 1 || infos[0].Latest != 2 || len(infos[0].Versions) != 2 {
+		t.Errorf("unexpected templates %+v", infos)
+	}
+}
+
+func Test_TemplateRegistry_ReloadKeepsLoaded(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "welcome.plain.gohtml")
+
+	err := os.WriteFile(path, []byte(`{{ define "body" }}Hi{{ end }}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := NewTemplateRegistry(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the template, as if it were being edited
+	err = os.WriteFile(path, []byte(`{{ define "body" }}Hi`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reg.Get("welcome", 0); err != nil {
+		t.Errorf("expected the loaded template to be used but got %v", err)
+	}
+
+	if infos := reg.List(); len(infos) != 1 {
+		t.Errorf("unexpected templates %+v", infos)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+const (
+	TRANSPORT_SMTP   = "smtp"   // Send mail to an SMTP server, through a pool of connections
+	TRANSPORT_FILE   = "file"   // Write each mail to its own .eml file in a directory
+	TRANSPORT_MBOX   = "mbox"   // Append every mail to a single mbox file
+	TRANSPORT_MEMORY = "memory" // Keep mail in memory, for tests. Nothing is delivered
+	TRANSPORT_HTTP   = "http"   // Post mail to an email provider's HTTP API
+
+	HTTP_TRANSPORT_TIMEOUT = 10 * time.Second // How long to wait for an email provider's API to answer
+)
+
+// Transport delivers emails once they're built. Every driver gets the same message,
+// so the mail service works the same whichever one it's using. Send returns what the
+// server it was handed to replied, if the driver knows. It never makes a reply up
+type Transport interface {
+	Send(email *mail.Email) (string, error)
+	Close() error
+}
+
+// newTransport creates the transport driver with the given name. Each driver reads
+// whatever else it needs from the environment:
+//
+//   - smtp, the default, connects to MAIL_HOST and keeps up to MAIL_POOL_SIZE connections
+//   - file writes .eml files to the MAIL_FILE_PATH directory
+//   - mbox appends to the MAIL_FILE_PATH file
+//   - memory keeps every mail in memory
+//   - http posts to MAIL_API_URL, authenticated with MAIL_API_KEY if it is set
+func newTransport(name string, m *Mail) (Transport, error) {
+
+	switch name {
+	case TRANSPORT_SMTP, "":
+		poolSize, _ := strconv.Atoi(os.Getenv("MAIL_POOL_SIZE"))
+		return NewSMTPPool(m.smtpServer(), poolSize), nil
+	case TRANSPORT_FILE:
+		return NewFileTransport(os.Getenv("MAIL_FILE_PATH"), false)
+	case TRANSPORT_MBOX:
+		return NewFileTransport(os.Getenv("MAIL_FILE_PATH"), true)
+	case TRANSPORT_MEMORY:
+		return NewMemoryTransport(), nil
+	case TRANSPORT_HTTP:
+		return NewHTTPTransport(os.Getenv("MAIL_API_URL"), os.Getenv("MAIL_API_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", name)
+	}
+}
+
+// rawMessage builds an email into an RFC 822 message, for the drivers that don't
+// speak SMTP
+func rawMessage(email *mail.Email) (string, error) {
+
+	if email.Error != nil {
+		return "", email.Error
+	}
+
+	if len(email.GetRecipients()) == 0 {
+		return "", errors.New("no recipient specified")
+	}
+
+	return email.GetMessage(), nil
+}
+
+// FileTransport writes mail to disk instead of sending it, either as one .eml file
+// per mail in a directory, or appended to a single mbox file. Either can be opened
+// with a mail client, which is handy in development
+type FileTransport struct {
+	path string
+	mbox bool
+	mu   sync.Mutex
+	sent int
+}
+
+// NewFileTransport creates a transport that writes to the given directory, or the
+// given mbox file if mbox is set. The directory, or the file's directory, is created
+// if it doesn't exist
+func NewFileTransport(path string, mbox bool) (*FileTransport, error) {
+
+	if path == "" {
+		return nil, errors.New("MAIL_FILE_PATH must be set to write mail to files")
+	}
+
+	dir := path
+	if mbox {
+		dir = filepath.Dir(path)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{path: path, mbox: mbox}, nil
+}
+
+// Send writes the email to disk
+func (t *FileTransport) Send(email *mail.Email) (string, error) {
+
+	raw, err := rawMessage(email)
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent++
+
+	if !t.mbox {
+		name := fmt.Sprintf("%s-%d.eml", time.Now().UTC().Format("20060102T150405.000000000"), t.sent)
+		return "", os.WriteFile(filepath.Join(t.path, name), []byte(raw), 0o644)
+	}
+
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.WriteString(mboxEntry(email.GetFrom(), raw, time.Now()))
+	if err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	return "", f.Close()
+}
+
+// Close does nothing, since files are closed after every mail
+func (t *FileTransport) Close() error {
+	return nil
+}
+
+// mboxEntry formats a message as an mbox entry: a "From " separator line, then the
+// message, with any of its lines that start with "From " quoted so they aren't
+// mistaken for the next separator
+func mboxEntry(from, raw string, date time.Time) string {
+
+	var b strings.Builder
+
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+
+	fmt.Fprintf(&b, "From %s %s\n", from, date.UTC().Format(time.ANSIC))
+
+	for _, line := range strings.Split(strings.TrimSuffix(raw, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// CapturedMail is a mail kept by the memory transport
+type CapturedMail struct {
+	From       string
+	Recipients []string // Everyone it was sent to, including BCC
+	Subject    string
+	Header     netmail.Header
+	Raw        string
+}
+
+// MemoryTransport keeps every mail it's given, instead of sending it, so tests can
+// check what would have been sent
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []CapturedMail
+}
+
+// NewMemoryTransport creates a transport that keeps mail in memory
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send keeps the email
+func (t *MemoryTransport) Send(email *mail.Email) (string, error) {
+
+	raw, err := rawMessage(email)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := netmail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = append(t.sent, CapturedMail{
+		From:       email.GetFrom(),
+		Recipients: append([]string(nil), email.GetRecipients()...),
+		Subject:    subject,
+		Header:     msg.Header,
+		Raw:        raw,
+	})
+
+	return "", nil
+}
+
+// Sent returns every mail kept so far, oldest first
+func (t *MemoryTransport) Sent() []CapturedMail {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]CapturedMail(nil), t.sent...)
+}
+
+// Reset forgets every mail kept so far
+func (t *MemoryTransport) Reset() {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = nil
+}
+
+// Close does nothing
+func (t *MemoryTransport) Close() error {
+	return nil
+}
+
+// HTTPTransport posts mail to an email provider's HTTP API, or a local stub of one.
+// Each mail is posted as JSON, with the whole RFC 822 message in "raw":
+//
+//	{"from": "me@example.com", "to": ["you@example.com"], "raw": "From: ..."}
+//
+// Any 2xx response means the mail was accepted
+type HTTPTransport struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPTransport creates a transport that posts mail to the given URL. If apiKey
+// isn't empty, it's sent as a bearer token
+func NewHTTPTransport(url, apiKey string) (*HTTPTransport, error) {
+
+	if url == "" {
+		return nil, errors.New("MAIL_API_URL must be set to send mail over HTTP")
+	}
+
+	return &HTTPTransport{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: HTTP_TRANSPORT_TIMEOUT},
+	}, nil
+}
+
+// Send posts the email to the API, and returns the API's response status
+func (t *HTTPTransport) Send(email *mail.Email) (string, error) {
+
+	raw, err := rawMessage(email)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"from": email.GetFrom(),
+		"to":   email.GetRecipients(),
+		"raw":  raw,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return res.Status, fmt.Errorf("mail API returned %s: %s", res.Status, strings.TrimSpace(string(reason)))
+	}
+
+	return res.Status, nil
+}
+
+// Close closes any idle connections to the API
+func (t *HTTPTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
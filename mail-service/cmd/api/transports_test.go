@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMailer returns a Mail that keeps what it sends in memory, rendering a single
+// "mail" template
+func testMailer(t *testing.T) (Mail, *MemoryTransport) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "mail.plain.gohtml"), []byte(`{{ define "body" }}{{ .message }}{{ end }}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := NewTemplateRegistry(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewMemoryTransport()
+
+	return Mail{FromAddress: "me@example.com", Templates: templates, Transport: transport}, transport
+}
+
+func Test_Mail_SendMessage_Memory(t *testing.T) {
+	m, transport := testMailer(t)
+
+	_, err := m.SendMessage(Message{
+		To:      []string{"you@example.com"},
+		BCC:     []string{"boss@example.com"},
+		Subject: "Héllo",
+		Data:    "Hi there",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := transport.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 mail but got %d", len(sent))
+	}
+
+	got := sent[0]
+	if got.From != "me@example.com" || got.Subject != "Héllo" || len(got.Recipients) != 2 {
+		t.Errorf("unexpected mail %+v", got)
+	}
+
+	if got.Header.Get("Bcc") != "" || !strings.Contains(got.Raw, "Hi there") {
+		t.Errorf("unexpected message %q", got.Raw)
+	}
+
+	transport.Reset()
+	if len(transport.Sent()) != 0 {
+		t.Error("expected Reset to forget every mail")
+	}
+}
+
+func Test_mboxEntry(t *testing.T) {
+	raw := "Subject: Hi\r\n\r\nFrom here on\r\n>From there\r\n"
+
+	entry := mboxEntry("me@example.com", raw, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	want := "From me@example.com Tue Jan  2 03:04:05 2024\nSubject: Hi\n\n>From here on\n>>From there\n\n"
+	if entry != want {
+		t.Errorf("expected %q but got %q", want, entry)
+	}
+}
+
+func Test_HTTPTransport(t *testing.T) {
+	var got map[string]any
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad key", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer stub.Close()
+
+	m, _ := testMailer(t)
+	msg := Message{To: []string{"you@example.com"}, Subject: "Hi", Data: "Hello"}
+
+	m.Transport, _ = NewHTTPTransport(stub.URL, "secret")
+	response, err := m.SendMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response != "202 Accepted" {
+		t.Errorf("expected the API's status but got %q", response)
+	}
+
+	if got["from"] != "me@example.com" || !strings.Contains(got["raw"].(string), "Hello") {
+		t.Errorf("unexpected request %v", got)
+	}
+
+	m.Transport, _ = NewHTTPTransport(stub.URL, "wrong")
+	_, err = m.SendMessage(msg)
+	if !errors.Is(err, ErrDelivery) || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("expected the API's error but got %v", err)
+	}
+}
+
+func Test_SendMail_DeliveryFailure(t *testing.T) {
+	app, _ := testQueueApp(t, failingTransport{})
+
+	// A mail that's fine, but can't be delivered, isn't the client's fault
+	res := httptest.NewRecorder()
+	app.SendMail(res, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"to":"you@example.com","subject":"Hi","message":"Hello"}`)))
+
+	if res.Code != http.StatusBadGateway {
+		t.Errorf("expected %d but got %d", http.StatusBadGateway, res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	app.SendMail(res, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"to":"nobody","subject":"Hi"}`)))
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected %d but got %d", http.StatusBadRequest, res.Code)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/BlackSound1/go-microservices/mail/suppressions"
+	"github.com/go-chi/chi/v5"
+)
+
+// ErrSuppressed is returned for a message whose every recipient is on the
+// suppression list. Retrying won't help
+var ErrSuppressed = errors.New("every recipient is suppressed")
+
+// ErrInvalidToken is returned for unsubscribe tokens that weren't signed by us
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// unsubscribePage is shown to people who follow the unsubscribe link in a mail. The
+// link only asks them to confirm, since mail scanners follow links too. Unsubscribing
+// is a POST, which is also what mail clients send for one-click unsubscribes
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8">
+        <title>Unsubscribe</title>
+    </head>
+    <body>
+        {{ if .Error }}
+            <p>{{ .Error }}</p>
+        {{ else if .Done }}
+            <p>{{ .Address }} won't get any more mail from us.</p>
+        {{ else }}
+            <form method="post">
+                <p>Stop sending mail to {{ .Address }}?</p>
+                <button type="submit">Unsubscribe</button>
+            </form>
+        {{ end }}
+    </body>
+</html>
+`))
+
+// suppress leaves out every recipient on the suppression list. It returns
+// ErrSuppressed if nobody is left
+func (m *Mail) suppress(msg Message) (Message, error) {
+
+	if m.Suppressions == nil {
+		return msg, nil
+	}
+
+	var suppressed []string
+
+	keep := func(addresses []string) []string {
+		var kept []string
+		for _, address := range addresses {
+			if m.Suppressions.Suppressed(address) {
+				suppressed = append(suppressed, address)
+				continue
+			}
+			kept = append(kept, address)
+		}
+		return kept
+	}
+
+	msg.To, msg.CC, msg.BCC = keep(msg.To), keep(msg.CC), keep(msg.BCC)
+
+	if len(msg.To)+len(msg.CC)+len(msg.BCC) == 0 {
+		return msg, fmt.Errorf("%w: %s", ErrSuppressed, strings.Join(suppressed, ", "))
+	}
+
+	return msg, nil
+}
+
+// addUnsubscribe gives a message a signed link its recipient can unsubscribe with,
+// both as a List-Unsubscribe header and as "unsubscribe_url" in its template data.
+//
+// A link can only unsubscribe one address, so messages with more than one recipient
+// don't get one. Neither do messages that set their own List-Unsubscribe header, nor
+// any message if unsubscribe links aren't set up
+func (m *Mail) addUnsubscribe(msg Message) Message {
+
+	recipients := append(append(append([]string(nil), msg.To...), msg.CC...), msg.BCC...)
+	if m.UnsubscribeURL == "" || len(m.UnsubscribeSecret) == 0 || len(recipients) != 1 {
+		return msg
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for name, value := range msg.Headers {
+		if textproto.CanonicalMIMEHeaderKey(name) == "List-Unsubscribe" {
+			return msg
+		}
+		headers[name] = value
+	}
+
+	link, err := m.unsubscribeLink(recipients[0])
+	if err != nil {
+		return msg
+	}
+
+	// One-click unsubscribes, from RFC 8058, POST to the link
+	headers["List-Unsubscribe"] = "<" + link + ">"
+	headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+
+	msg.Headers = headers
+	msg.UnsubscribeURL = link
+
+	return msg
+}
+
+// unsubscribeLink returns the link that unsubscribes an address
+func (m *Mail) unsubscribeLink(address string) (string, error) {
+
+	address, err := suppressions.Normalize(address)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := url.Parse(m.UnsubscribeURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", m.unsubscribeToken(address))
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+// unsubscribeToken signs an address, so only we can make links that unsubscribe it.
+// The token is the address and its HMAC-SHA256, both base64 encoded
+func (m *Mail) unsubscribeToken(address string) string {
+
+	mac := hmac.New(sha256.New, m.UnsubscribeSecret)
+	mac.Write([]byte(address))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(address)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUnsubscribeToken returns the address a token unsubscribes, if it's ours
+func (m *Mail) verifyUnsubscribeToken(token string) (string, error) {
+
+	if len(m.UnsubscribeSecret) == 0 {
+		return "", ErrInvalidToken
+	}
+
+	encoded, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	address, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(token), []byte(m.unsubscribeToken(string(address)))) {
+		return "", ErrInvalidToken
+	}
+
+	return string(address), nil
+}
+
+// Unsubscribe handles the unsubscribe links in mail. A GET asks the recipient to
+// confirm, and a POST, from that page or a mail client's one-click unsubscribe, adds
+// them to the suppression list
+func (app *Config) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+
+	var page struct {
+		Address string
+		Done    bool
+		Error   string
+	}
+
+	status := http.StatusOK
+
+	address, err := app.Mailer.verifyUnsubscribeToken(r.URL.Query().Get("token"))
+	switch {
+	case err != nil:
+		status = http.StatusBadRequest
+		page.Error = "This unsubscribe link isn't valid."
+	case r.Method == http.MethodPost:
+		_, err = app.Mailer.Suppressions.Add(address, suppressions.UNSUBSCRIBE)
+		if err != nil {
+			status = http.StatusInternalServerError
+			page.Error = "Something went wrong. Please try again later."
+		} else {
+			page.Done = true
+		}
+	}
+
+	page.Address = address
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = unsubscribePage.Execute(w, page)
+}
+
+// SuppressionPayload is an address to add to the suppression list
+type SuppressionPayload struct {
+	Address string              `json:"address"`
+	Reason  suppressions.Reason `json:"reason,omitempty"` // Defaults to "manual"
+}
+
+// ListSuppressions lists every suppressed address
+func (app *Config) ListSuppressions(w http.ResponseWriter, r *http.Request) {
+
+	entries := app.Mailer.Suppressions.All()
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d suppressed addresses", len(entries)),
+		Data:    entries,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// AddSuppression stops mail being sent to an address, like one that has bounced
+func (app *Config) AddSuppression(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload SuppressionPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entry, err := app.Mailer.Suppressions.Add(requestPayload.Address, requestPayload.Reason)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "suppressed " + entry.Address,
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// RemoveSuppression lets mail be sent to an address again
+func (app *Config) RemoveSuppression(w http.ResponseWriter, r *http.Request) {
+
+	address, err := url.PathUnescape(chi.URLParam(r, "address"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Mailer.Suppressions.Remove(address)
+	if errors.Is(err, suppressions.ErrNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: "removed " + address + " from the suppression list",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
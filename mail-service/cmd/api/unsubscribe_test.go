@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BlackSound1/go-microservices/httputil"
+	"github.com/BlackSound1/go-microservices/mail/suppressions"
+)
+
+func Test_Mail_Unsubscribe(t *testing.T) {
+	m, transport := testMailer(t)
+
+	list, err := suppressions.Open(filepath.Join(t.TempDir(), "suppressions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Suppressions = list
+	m.UnsubscribeURL = "http://localhost:8080/mail/unsubscribe"
+	m.UnsubscribeSecret = []byte("secret")
+
+	_, err = m.SendMessage(Message{To: []string{"You <you@example.com>"}, Subject: "Hi", Data: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single recipient gets a signed link to unsubscribe with
+	header := transport.Sent()[0].Header.Get("List-Unsubscribe")
+	if !strings.HasPrefix(header, "<"+m.UnsubscribeURL+"?token=") {
+		t.Fatalf("expected an unsubscribe link but got %q", header)
+	}
+
+	link, _ := url.Parse(strings.Trim(header, "<>"))
+	token := link.Query().Get("token")
+
+	address, err := m.verifyUnsubscribeToken(token)
+	if err != nil || address != "you@example.com" {
+		t.Fatalf("expected the token to be for you@example.com but got %q (%v)", address, err)
+	}
+
+	if _, err := m.verifyUnsubscribeToken("eW91QGV4YW1wbGUuY29t.forged"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a forged token to be refused but got %v", err)
+	}
+
+	// Following the link only asks to confirm, and posting to it unsubscribes
+	app := Config{Mailer: m}
+
+	res := httptest.NewRecorder()
+	app.Unsubscribe(res, httptest.NewRequest(http.MethodGet, "/unsubscribe?token="+url.QueryEscape(token), nil))
+	if res.Code != http.StatusOK || list.Suppressed("you@example.com") {
+		t.Fatalf("expected to be asked to confirm but got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	app.Unsubscribe(res, httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+url.QueryEscape(token), strings.NewReader("List-Unsubscribe=One-Click")))
+	if res.Code != http.StatusOK || !list.Suppressed("you@example.com") {
+		t.Fatalf("expected to be unsubscribed but got %d", res.Code)
+	}
+
+	// Suppressed recipients are left out, and a mail with nobody left isn't sent
+	transport.Reset()
+
+	_, err = m.SendMessage(Message{To: []string{"you@example.com", "them@example.com"}, Subject: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := transport.Sent(); len(sent) != 1 || len(sent[0].Recipients) != 1 || sent[0].Recipients[0] != "them@example.com" {
+		t.Errorf("expected only them@example.com to get the mail but got %+v", sent)
+	}
+
+	_, err = m.SendMessage(Message{To: []string{"you@example.com"}, Subject: "Hi"})
+	if !errors.Is(err, ErrSuppressed) {
+		t.Errorf("expected ErrSuppressed but got %v", err)
+	}
+}
+
+func Test_checkSecret(t *testing.T) {
+	for secret, ok := range map[string]bool{
+		"short":                   false,
+		"change-me-in-production": false,
+		"change-me-in-production-and-make-it-longer": false,
+		"q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf":           true,
+	} {
+		if err := checkSecret("SECRET", secret); (err == nil) != ok {
+			t.Errorf("%q: expected ok to be %v but got %v", secret, ok, err)
+		}
+	}
+}
+
+func Test_Suppressions_AdminKey(t *testing.T) {
+	m, _ := testMailer(t)
+
+	list, err := suppressions.Open(filepath.Join(t.TempDir(), "suppressions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Suppressions = list
+
+	// Only requests with the admin key get through, and none do without one
+	for _, test := range []struct {
+		adminKey, key string
+		want          int
+	}{
+		{"q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf", "q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf", http.StatusOK},
+		{"q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf", "wrong", http.StatusUnauthorized},
+		{"q8Vt2xN0aLr5GmZ3yKc7WbE1uHs9PdJf", "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		app := Config{Mailer: m, AdminKey: test.adminKey}
+
+		req := httptest.NewRequest(http.MethodGet, "/suppressions", nil)
+		if test.key != "" {
+			req.Header.Set(httputil.ADMIN_KEY_HEADER, test.key)
+		}
+
+		res := httptest.NewRecorder()
+		app.routes().ServeHTTP(res, req)
+
+		if res.Code != test.want {
+			t.Errorf("%q with %q: expected %d but got %d", test.adminKey, test.key, test.want, res.Code)
+		}
+	}
+}
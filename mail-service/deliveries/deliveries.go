@@ -0,0 +1,219 @@
+package deliveries
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/events"
+	"github.com/BlackSound1/go-microservices/fileutil"
+)
+
+// Status is where a message is on its way to being delivered
+type Status string
+
+const (
+	QUEUED  Status = "queued"  // Waiting to be sent, or to be retried
+	SENDING Status = "sending" // Being handed to the mail server
+	SENT    Status = "sent"    // Accepted by the mail server
+	FAILED  Status = "failed"  // Given up on
+)
+
+// ErrNotFound is returned when there's no delivery record for a message
+var ErrNotFound = errors.New("message not found")
+
+// validID matches the IDs records can be kept under, so an ID can never point
+// outside the store's directory
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Delivery is the record of a single message, and how its delivery is going
+type Delivery struct {
+	ID         string     `json:"id"`
+	Status     Status     `json:"status"`
+	Recipients []string   `json:"recipients"`
+	Subject    string     `json:"subject,omitempty"`
+	Template   string     `json:"template,omitempty"`
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"last_error,omitempty"`
+	Response   string     `json:"response,omitempty"` // What the mail server last said about it, if anything
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+}
+
+// Store keeps delivery records on the local filesystem, with one file per message.
+// A record being updated when the mail service stops keeps its last complete status.
+// Records are kept until Prune deletes them
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Open opens the store in the given directory, creating it if it doesn't exist
+func Open(dir string) (*Store, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Create records a new message, giving it an ID if it doesn't have one. If there's
+// already a record with its ID, like when a job is redelivered, that record is
+// returned instead
+func (s *Store) Create(d Delivery) (Delivery, error) {
+
+	if d.ID == "" {
+		id, err := events.NewID()
+		if err != nil {
+			return Delivery{}, err
+		}
+		d.ID = id
+	}
+
+	if !validID.MatchString(d.ID) {
+		return Delivery{}, fmt.Errorf("invalid message ID %q", d.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.read(d.ID)
+	if err == nil {
+		return existing, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return Delivery{}, err
+	}
+
+	now := time.Now().UTC()
+	d.CreatedAt = now
+	d.UpdatedAt = now
+
+	err = s.write(d)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	return d, nil
+}
+
+// Get returns the record of a message
+func (s *Store) Get(id string) (Delivery, error) {
+
+	if !validID.MatchString(id) {
+		return Delivery{}, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(id)
+}
+
+// Update changes the record of a message, and returns it as it now is
+func (s *Store) Update(id string, change func(d *Delivery)) (Delivery, error) {
+
+	if !validID.MatchString(id) {
+		return Delivery{}, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, err := s.read(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	change(&d)
+	d.UpdatedAt = time.Now().UTC()
+
+	err = s.write(d)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	return d, nil
+}
+
+// Prune deletes the records of messages that were sent or given up on before the
+// given time, and returns how many it deleted. Messages still on their way keep their
+// records however old they are, and so do files that can't be read
+func (s *Store) Prune(before time.Time) (int, error) {
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || !validID.MatchString(id) {
+			continue
+		}
+
+		d, err := s.read(id)
+		if err != nil {
+			continue
+		}
+
+		if (d.Status != SENT && d.Status != FAILED) || !d.UpdatedAt.Before(before) {
+			continue
+		}
+
+		err = os.Remove(s.path(id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, err
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// read reads a record from its file
+func (s *Store) read(id string) (Delivery, error) {
+
+	b, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Delivery{}, ErrNotFound
+	} else if err != nil {
+		return Delivery{}, err
+	}
+
+	var d Delivery
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("reading delivery %s: %w", id, err)
+	}
+
+	return d, nil
+}
+
+// write saves a record to its file, replacing it if it already exists
+func (s *Store) write(d Delivery) error {
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(s.path(d.ID), b)
+}
+
+// path returns the file a record is kept in
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,87 @@
+package deliveries
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Store(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := store.Create(Delivery{Status: QUEUED, Recipients: []string{"you@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.ID == "" || d.CreatedAt.IsZero() {
+		t.Fatalf("expected an ID and a creation time but got %+v", d)
+	}
+
+	updated, err := store.Update(d.ID, func(d *Delivery) {
+		d.Status = SENT
+		d.Attempts = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != SENT || got.Attempts != 2 || !got.UpdatedAt.Equal(updated.UpdatedAt) {
+		t.Errorf("expected the update to be kept but got %+v", got)
+	}
+
+	// Creating it again, like a redelivered job would, keeps what's already there
+	again, err := store.Create(Delivery{ID: d.ID, Status: QUEUED})
+	if err != nil || again.Status != SENT {
+		t.Errorf("expected the existing record but got %+v (%v)", again, err)
+	}
+
+	if _, err := store.Get("../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound but got %v", err)
+	}
+
+	if _, err := store.Update("missing", func(*Delivery) {}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound but got %v", err)
+	}
+}
+
+func Test_Store_Prune(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, _ := store.Create(Delivery{Status: SENT})
+	failed, _ := store.Create(Delivery{Status: FAILED})
+	queued, _ := store.Create(Delivery{Status: QUEUED})
+
+	// Nothing is old enough yet
+	n, err := store.Prune(time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("expected nothing to be pruned but got %d (%v)", n, err)
+	}
+
+	// Finished messages go, but ones still on their way stay
+	n, err = store.Prune(time.Now().Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 records to be pruned but got %d (%v)", n, err)
+	}
+
+	for _, id := range []string{sent.ID, failed.ID} {
+		if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %s to be pruned but got %v", id, err)
+		}
+	}
+
+	if _, err := store.Get(queued.ID); err != nil {
+		t.Errorf("expected the queued message to be kept but got %v", err)
+	}
+}
@@ -2,15 +2,28 @@ module github.com/BlackSound1/go-microservices/mail
 
 go 1.23.1
 
+require (
+	github.com/BlackSound1/go-microservices/events v0.0.0
+	github.com/BlackSound1/go-microservices/fileutil v0.0.0
+	github.com/BlackSound1/go-microservices/httputil v0.0.0
+	github.com/go-chi/chi/v5 v5.1.0
+	github.com/go-chi/cors v1.2.1
+	github.com/rabbitmq/amqp091-go v1.10.0
+	github.com/vanng822/go-premailer v1.22.0
+	github.com/xhit/go-simple-mail/v2 v2.16.0
+)
+
 require (
 	github.com/PuerkitoBio/goquery v1.9.2 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
-	github.com/go-chi/chi/v5 v5.1.0 // indirect
-	github.com/go-chi/cors v1.2.1 // indirect
 	github.com/gorilla/css v1.0.1 // indirect
 	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208 // indirect
 	github.com/vanng822/css v1.0.1 // indirect
-	github.com/vanng822/go-premailer v1.22.0 // indirect
-	github.com/xhit/go-simple-mail/v2 v2.16.0 // indirect
 	golang.org/x/net v0.29.0 // indirect
 )
+
+replace github.com/BlackSound1/go-microservices/events => ../events
+
+replace github.com/BlackSound1/go-microservices/fileutil => ../fileutil
+
+replace github.com/BlackSound1/go-microservices/httputil => ../httputil
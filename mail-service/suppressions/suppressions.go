@@ -0,0 +1,203 @@
+package suppressions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackSound1/go-microservices/fileutil"
+)
+
+// Reason is why mail must not be sent to an address
+type Reason string
+
+const (
+	BOUNCE      Reason = "bounce"      // Mail to it bounced
+	COMPLAINT   Reason = "complaint"   // Its owner reported mail as spam
+	UNSUBSCRIBE Reason = "unsubscribe" // Its owner opted out
+	MANUAL      Reason = "manual"      // Someone added it by hand
+)
+
+// ErrNotFound is returned when an address isn't on the list
+var ErrNotFound = errors.New("address is not suppressed")
+
+// Entry is a single address on the list
+type Entry struct {
+	Address   string    `json:"address"`
+	Reason    Reason    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// List is every address mail must not be sent to. It's kept in memory, and saved to
+// a JSON file whenever it changes. If the mail service stops while it's being saved,
+// the file still holds the whole list from before the change.
+//
+// Addresses are compared without their display names and case insensitively, so
+// "Bob <BOB@example.com>" is suppressed along with "bob@example.com"
+type List struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string]Entry
+}
+
+// Open loads the list from the given file, or starts an empty one if the file
+// doesn't exist yet
+func Open(path string) (*List, error) {
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &List{path: path, entries: make(map[string]Entry)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("reading suppression list %s: %w", path, err)
+	}
+
+	for _, e := range entries {
+		l.entries[e.Address] = e
+	}
+
+	return l, nil
+}
+
+// Normalize returns the bare, lowercase address the list keeps for an address
+func Normalize(address string) (string, error) {
+
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+
+	return strings.ToLower(parsed.Address), nil
+}
+
+// Add suppresses an address. Adding one that's already on the list keeps its
+// original entry
+func (l *List) Add(address string, reason Reason) (Entry, error) {
+
+	switch reason {
+	case "":
+		reason = MANUAL
+	case BOUNCE, COMPLAINT, UNSUBSCRIBE, MANUAL:
+	default:
+		return Entry{}, fmt.Errorf("unknown suppression reason %q", reason)
+	}
+
+	address, err := Normalize(address)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[address]; ok {
+		return e, nil
+	}
+
+	e := Entry{Address: address, Reason: reason, CreatedAt: time.Now().UTC()}
+	l.entries[address] = e
+
+	err = l.save()
+	if err != nil {
+		delete(l.entries, address)
+		return Entry{}, err
+	}
+
+	return e, nil
+}
+
+// Remove takes an address off the list, so it can be mailed again
+func (l *List) Remove(address string) error {
+
+	address, err := Normalize(address)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[address]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(l.entries, address)
+
+	err = l.save()
+	if err != nil {
+		l.entries[address] = e
+		return err
+	}
+
+	return nil
+}
+
+// Suppressed reports whether mail to an address must not be sent. Addresses that
+// can't be parsed aren't on the list
+func (l *List) Suppressed(address string) bool {
+
+	address, err := Normalize(address)
+	if err != nil {
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.entries[address]
+
+	return ok
+}
+
+// All returns every entry, sorted by address
+func (l *List) All() []Entry {
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.sorted()
+}
+
+// sorted returns every entry, sorted by address. The caller must hold the lock
+func (l *List) sorted() []Entry {
+
+	entries := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+
+	return entries
+}
+
+// save writes the list to its file. The caller must hold the lock
+func (l *List) save() error {
+
+	b, err := json.MarshalIndent(l.sorted(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(l.path, b)
+}
@@ -0,0 +1,52 @@
+package suppressions
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func Test_List(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressions.json")
+
+	list, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := list.Add("Bob <BOB@example.com>", BOUNCE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Address != "bob@example.com" || entry.Reason != BOUNCE {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if !list.Suppressed("bob@EXAMPLE.com") || list.Suppressed("alice@example.com") {
+		t.Error("expected only bob to be suppressed")
+	}
+
+	if _, err := list.Add("alice@example.com", "bored"); err == nil {
+		t.Error("expected an unknown reason to be refused")
+	}
+
+	// The list is still there when it's opened again
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if all := reopened.All(); len(all) != 1 || all[0] != entry {
+		t.Fatalf("expected the saved entry but got %+v", all)
+	}
+
+	err = reopened.Remove("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reopened.Remove("bob@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound but got %v", err)
+	}
+}
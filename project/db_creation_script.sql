@@ -35,6 +35,7 @@ CREATE TABLE
             last_name CHARACTER varying(255),
             password CHARACTER varying(60),
             user_active INTEGER DEFAULT 0,
+            user_admin INTEGER DEFAULT 0,
             created_at TIMESTAMP WITHOUT TIME ZONE,
             updated_at TIMESTAMP WITHOUT TIME ZONE
         );
@@ -59,8 +60,34 @@ ADD CONSTRAINT
     users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: refresh_tokens; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE 
+    public.refresh_tokens 
+        (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+            token_hash CHARACTER(64) NOT NULL UNIQUE,
+            family_id CHARACTER varying(64) NOT NULL,
+            expires_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+            revoked_at TIMESTAMP WITHOUT TIME ZONE,
+            replaced_by INTEGER REFERENCES public.refresh_tokens (id),
+            created_at TIMESTAMP WITHOUT TIME ZONE
+        );
+
+
+ALTER TABLE public.refresh_tokens OWNER TO postgres;
+
+CREATE INDEX 
+    refresh_tokens_family_id_idx 
+ON 
+    public.refresh_tokens (family_id);
+
+
 INSERT INTO 
     "public"."users"
-        ("email","first_name","last_name","password","user_active","created_at","updated_at")
+        ("email","first_name","last_name","password","user_active","user_admin","created_at","updated_at")
 VALUES
-    (E'admin@example.com',E'Admin',E'User',E'$2a$12$1zGLuYDDNvATh4RA4avbKuheAMpb1svexSzrQm7up.bnpwQHs0jNe',1,E'2022-03-14 00:00:00',E'2022-03-14 00:00:00');
+    (E'admin@example.com',E'Admin',E'User',E'$2a$12$1zGLuYDDNvATh4RA4avbKuheAMpb1svexSzrQm7up.bnpwQHs0jNe',1,1,E'2022-03-14 00:00:00',E'2022-03-14 00:00:00');